@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	pulseServerEnv        = "PULSE_SERVER"
+	pulseSocketName       = "pulse/native"
+	pipeWireSocketName    = "pipewire-0"
+	pulseServerUnixScheme = "unix:"
+)
+
+// hostPulseSocket returns the path of the local PulseAudio socket. An
+// explicitly configured Unix socket takes precedence.
+func hostPulseSocket(runtimeDir string) string {
+	if server, ok := strings.CutPrefix(os.Getenv(pulseServerEnv), pulseServerUnixScheme); ok && filepath.IsAbs(server) {
+		return server
+	}
+
+	return filepath.Join(runtimeDir, pulseSocketName)
+}
+
+// setupAudio mounts the PulseAudio and PipeWire sockets into the container's
+// runtime directory. At least one of them must exist. PipeWire usually
+// provides a PulseAudio-compatible socket as well.
+func (p *program) setupAudio(r *runtime, mounts *mountSet, env envMap) error {
+	runtimeDir := hostRuntimeDir()
+
+	pulseSocket := hostPulseSocket(runtimeDir)
+	pipeWireSocket := filepath.Join(runtimeDir, pipeWireSocketName)
+
+	found := false
+
+	for _, i := range []struct {
+		socket string
+		name   string
+	}{
+		{pulseSocket, pulseSocketName},
+		{pipeWireSocket, pipeWireSocketName},
+	} {
+		if ok, err := fileExists(i.socket); err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+
+		dst, err := bindRuntimeSocket(r, mounts, env, mountOriginAudio, i.socket, i.name)
+		if err != nil {
+			return err
+		}
+
+		if i.name == pulseSocketName {
+			server := pulseServerUnixScheme + dst
+			env[pulseServerEnv] = &server
+		}
+
+		found = true
+	}
+
+	if !found {
+		return fmt.Errorf("neither PulseAudio socket at %s nor PipeWire socket at %s found", pulseSocket, pipeWireSocket)
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hansmi/cocoon/internal/testutil"
+)
+
+func TestProgramSetupAudio(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		files       []string
+		pulseServer string
+		want        func(runtimeDir, dir string) []bindMount
+		wantPulse   bool
+		wantErr     bool
+	}{
+		{
+			name:    "missing",
+			wantErr: true,
+		},
+		{
+			name:  "pipewire and pulse",
+			files: []string{"pulse/native", "pipewire-0"},
+			want: func(runtimeDir, dir string) []bindMount {
+				return []bindMount{
+					{src: dir, dst: dir, mode: mountReadWrite},
+					{src: filepath.Join(runtimeDir, "pipewire-0"), dst: filepath.Join(dir, "pipewire-0"), mode: mountReadOnly},
+					{src: filepath.Join(runtimeDir, "pulse/native"), dst: filepath.Join(dir, "pulse/native"), mode: mountReadOnly},
+				}
+			},
+			wantPulse: true,
+		},
+		{
+			name:  "pipewire only",
+			files: []string{"pipewire-0"},
+			want: func(runtimeDir, dir string) []bindMount {
+				return []bindMount{
+					{src: dir, dst: dir, mode: mountReadWrite},
+					{src: filepath.Join(runtimeDir, "pipewire-0"), dst: filepath.Join(dir, "pipewire-0"), mode: mountReadOnly},
+				}
+			},
+		},
+		{
+			name:        "pulse server",
+			files:       []string{"custom/pulse"},
+			pulseServer: "custom/pulse",
+			want: func(runtimeDir, dir string) []bindMount {
+				return []bindMount{
+					{src: dir, dst: dir, mode: mountReadWrite},
+					{src: filepath.Join(runtimeDir, "custom/pulse"), dst: filepath.Join(dir, "pulse/native"), mode: mountReadOnly},
+				}
+			},
+			wantPulse: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			runtimeDir := t.TempDir()
+
+			for _, i := range tc.files {
+				path := filepath.Join(runtimeDir, i)
+
+				if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+					t.Fatal(err)
+				}
+
+				testutil.MustWriteFile(t, path, "")
+			}
+
+			t.Setenv(xdgRuntimeDirEnv, runtimeDir)
+			t.Setenv(pulseServerEnv, "")
+
+			if tc.pulseServer != "" {
+				t.Setenv(pulseServerEnv, pulseServerUnixScheme+filepath.Join(runtimeDir, tc.pulseServer))
+			}
+
+			p := newProgram()
+
+			r := &runtime{}
+			t.Cleanup(func() { os.RemoveAll(r.baseDir) })
+
+			mounts := newMountSet()
+			env := envMap{}
+
+			err := p.setupAudio(r, mounts, env)
+
+			if tc.wantErr {
+				if err == nil || !strings.Contains(err.Error(), filepath.Join(runtimeDir, "pulse/native")) {
+					t.Errorf("setupAudio() error %v doesn't mention the expected path", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("setupAudio() failed: %v", err)
+			}
+
+			dir := env[xdgRuntimeDirEnv]
+			if dir == nil {
+				t.Fatalf("%s not set", xdgRuntimeDirEnv)
+			}
+
+			if diff := cmp.Diff(tc.want(runtimeDir, *dir), mounts.list(), cmp.AllowUnexported(bindMount{})); diff != "" {
+				t.Errorf("Mount list diff (-want +got):\n%s", diff)
+			}
+
+			pulseServer := env[pulseServerEnv]
+
+			if tc.wantPulse {
+				if want := pulseServerUnixScheme + filepath.Join(*dir, "pulse/native"); pulseServer == nil || *pulseServer != want {
+					t.Errorf("%s is %v, want %q", pulseServerEnv, pulseServer, want)
+				}
+			} else if pulseServer != nil {
+				t.Errorf("%s set to %q", pulseServerEnv, *pulseServer)
+			}
+		})
+	}
+}
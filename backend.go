@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"io"
+)
+
+// Runtime-neutral description of a container invocation.
+type runSpec struct {
+	name   string
+	image  string
+	user   string
+	group  string
+	groups []string
+
+	// Supplementary groups of the invoking user given by ID.
+	forwardedGroups []string
+
+	workdir    string
+	readOnly   bool
+	mounts     []bindMount
+	tmpfs      []tmpfsMount
+	volumes    []volumeMount
+	projectDir string
+	labels     map[string]string
+	env        envMap
+
+	// Pass the environment using flags instead of a temporary file, e.g.
+	// for printing a self-contained command.
+	inlineEnv bool
+
+	tty        bool
+	entrypoint string
+	args       []string
+}
+
+// containerBackend is implemented by all supported container runtimes.
+type containerBackend interface {
+	// prepare creates the resources required by the specification, e.g.
+	// named volumes.
+	prepare(ctx context.Context, spec *runSpec) error
+
+	// command returns the command line for running a container according
+	// to the specification. Temporary files can be stored in the runtime's
+	// base directory.
+	command(r *runtime, spec *runSpec) ([]string, error)
+
+	// readImageFiles returns the content of files within the image. Missing
+	// files are omitted.
+	readImageFiles(ctx context.Context, image string, paths []string) (map[string][]byte, error)
+
+	// writeEnviron writes environment variables in the format used by the
+	// runtime.
+	writeEnviron(w io.Writer, environ envMap) error
+
+	// isRuntimeFailure reports whether an exit status was produced by the
+	// runtime itself instead of the command within the container.
+	isRuntimeFailure(status int) bool
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const dockerignoreFileName = ".dockerignore"
+
+// Pattern read from a .dockerignore file.
+type dockerignorePattern struct {
+	pattern string
+	exclude bool
+}
+
+// readDockerignore returns the patterns of the .dockerignore file in the
+// build context, if any.
+func readDockerignore(contextDir string) ([]dockerignorePattern, error) {
+	fh, err := os.Open(filepath.Join(contextDir, dockerignoreFileName))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	defer fh.Close()
+
+	var result []dockerignorePattern
+
+	scanner := bufio.NewScanner(fh)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		entry := dockerignorePattern{}
+
+		if value, ok := strings.CutPrefix(line, "!"); ok {
+			entry.exclude = true
+			line = strings.TrimSpace(value)
+		}
+
+		entry.pattern = filepath.Clean(strings.TrimPrefix(line, "/"))
+
+		if _, err := filepath.Match(entry.pattern, ""); err != nil {
+			return nil, fmt.Errorf("%s: invalid pattern %q: %w", dockerignoreFileName, line, err)
+		}
+
+		result = append(result, entry)
+	}
+
+	return result, scanner.Err()
+}
+
+// dockerignoreMatches reports whether a path relative to the build context
+// is ignored. Patterns apply to the path itself and its parent directories.
+// The last matching pattern wins. Unlike Docker the "**" wildcard isn't
+// supported.
+func dockerignoreMatches(patterns []dockerignorePattern, rel string) bool {
+	ignored := false
+
+	for _, p := range patterns {
+		for candidate := rel; candidate != "."; candidate = filepath.Dir(candidate) {
+			if ok, _ := filepath.Match(p.pattern, candidate); ok {
+				ignored = !p.exclude
+				break
+			}
+		}
+	}
+
+	return ignored
+}
+
+// dockerignoreMayReinclude reports whether an exclusion pattern, i.e. one
+// starting with "!", could match a path below the given directory. Patterns
+// only match paths with the same number of components.
+func dockerignoreMayReinclude(patterns []dockerignorePattern, dir string) bool {
+	dirParts := strings.Split(dir, string(filepath.Separator))
+
+	for _, p := range patterns {
+		if !p.exclude {
+			continue
+		}
+
+		parts := strings.Split(p.pattern, string(filepath.Separator))
+
+		if len(parts) <= len(dirParts) {
+			continue
+		}
+
+		matches := true
+
+		for idx, part := range dirParts {
+			if ok, _ := filepath.Match(parts[idx], part); !ok {
+				matches = false
+				break
+			}
+		}
+
+		if matches {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hashFile writes the length and content of a file to the hash.
+func hashFile(h hash.Hash, path string, size int64) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer fh.Close()
+
+	fmt.Fprintf(h, "%d\x00", size)
+
+	_, err = io.Copy(h, fh)
+
+	return err
+}
+
+// walkBuildContext calls fn for all entries within the build context which
+// aren't ignored. Ignored directories are only descended into if an exclusion
+// pattern could apply to their content.
+func walkBuildContext(contextDir string, patterns []dockerignorePattern, fn func(path, rel string, d fs.DirEntry) error) error {
+	return filepath.WalkDir(contextDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(contextDir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		if dockerignoreMatches(patterns, rel) {
+			if d.IsDir() && !dockerignoreMayReinclude(patterns, rel) {
+				return fs.SkipDir
+			}
+
+			// Excluded entries within an ignored directory need to be
+			// visited.
+			return nil
+		}
+
+		return fn(path, rel, d)
+	})
+}
+
+// buildContextHash returns a hash of the Dockerfile and the files within the
+// build context which aren't ignored via .dockerignore. File names, modes
+// and content are included.
+func buildContextHash(contextDir, dockerfile string) (string, error) {
+	patterns, err := readDockerignore(contextDir)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+
+	info, err := os.Stat(dockerfile)
+	if err != nil {
+		return "", err
+	}
+
+	if err := hashFile(h, dockerfile, info.Size()); err != nil {
+		return "", err
+	}
+
+	if err := walkBuildContext(contextDir, patterns, func(path, rel string, d fs.DirEntry) error {
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(h, "%s\x00%s\x00", rel, info.Mode())
+
+		switch {
+		case info.Mode().IsRegular():
+			return hashFile(h, path, info.Size())
+
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(h, "%s\x00", target)
+		}
+
+		return nil
+	}); err != nil {
+		return "", fmt.Errorf("hashing build context: %w", err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// imageNameComponent converts a name to the restricted character set of image
+// repository names.
+func imageNameComponent(name string) string {
+	var b strings.Builder
+
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else if s := b.String(); s != "" && !strings.HasSuffix(s, "-") {
+			b.WriteRune('-')
+		}
+	}
+
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// buildImageTag returns the tag for an image built for a project. The tag
+// changes whenever the Dockerfile or the build context changes.
+func buildImageTag(projectDir, contextHash string) string {
+	name := "cocoon"
+
+	if component := imageNameComponent(filepath.Base(projectDir)); component != "" {
+		name += "-" + component
+	}
+
+	return name + ":" + contextHash[:16]
+}
+
+// buildPaths returns the build context and Dockerfile. The context defaults
+// to the directory containing the Dockerfile and the Dockerfile to the file
+// named "Dockerfile" within the context.
+func (p *program) buildPaths() (string, string) {
+	contextDir, dockerfile := p.buildContext, p.dockerfile
+
+	if contextDir == "" {
+		contextDir = filepath.Dir(dockerfile)
+	}
+
+	if dockerfile == "" {
+		dockerfile = filepath.Join(contextDir, "Dockerfile")
+	}
+
+	return contextDir, dockerfile
+}
+
+// buildImage builds the image from the configured Dockerfile unless an image
+// with the same content hash already exists. The tag is returned. Build
+// output is written to standard error. Build failures are reported as
+// errors, never as the exit status of a command.
+func (p *program) buildImage(ctx context.Context) (string, error) {
+	cli, err := p.containerCli()
+	if err != nil {
+		return "", err
+	}
+
+	contextDir, dockerfile := p.buildPaths()
+
+	contextHash, err := buildContextHash(contextDir, dockerfile)
+	if err != nil {
+		return "", err
+	}
+
+	tag := buildImageTag(p.projectDir, contextHash)
+
+	if p.simulated() {
+		return tag, nil
+	}
+
+	if cli.imageExists(ctx, tag) {
+		return tag, nil
+	}
+
+	if err := cli.stream(ctx, p.stderr, "build",
+		"--file="+dockerfile,
+		"--tag="+tag,
+		"--label="+projectLabel+"="+p.projectDir,
+		contextDir); err != nil {
+		return "", fmt.Errorf("building image from %s: %w", dockerfile, err)
+	}
+
+	return tag, nil
+}
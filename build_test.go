@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hansmi/cocoon/internal/testutil"
+)
+
+func TestDockerignoreMatches(t *testing.T) {
+	dir := t.TempDir()
+
+	testutil.MustWriteFile(t, filepath.Join(dir, dockerignoreFileName), `
+# Comment
+.git
+/build
+*.log
+!important.log
+docs/*.md
+`)
+
+	patterns, err := readDockerignore(dir)
+	if err != nil {
+		t.Fatalf("readDockerignore() failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		path string
+		want bool
+	}{
+		{path: "main.go"},
+		{path: ".git", want: true},
+		{path: ".git/config", want: true},
+		{path: "build/output", want: true},
+		{path: "src/build"},
+		{path: "debug.log", want: true},
+		{path: "important.log"},
+		{path: "docs/index.md", want: true},
+		{path: "docs/sub/index.md"},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			if got := dockerignoreMatches(patterns, tc.path); got != tc.want {
+				t.Errorf("dockerignoreMatches(%q) returned %t, want %t", tc.path, got, tc.want)
+			}
+		})
+	}
+
+	if patterns, err := readDockerignore(t.TempDir()); err != nil {
+		t.Errorf("readDockerignore() failed: %v", err)
+	} else if len(patterns) > 0 {
+		t.Errorf("readDockerignore() returned patterns for missing file: %v", patterns)
+	}
+}
+
+func TestBuildContextHash(t *testing.T) {
+	dir := t.TempDir()
+	dockerfile := testutil.MustWriteFile(t, filepath.Join(dir, "Dockerfile.dev"), "FROM alpine\n")
+
+	if err := os.Mkdir(filepath.Join(dir, "src"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.MustWriteFile(t, filepath.Join(dir, dockerignoreFileName), "*.log\n")
+	testutil.MustWriteFile(t, filepath.Join(dir, "src", "main.go"), "package main\n")
+
+	hash := func() string {
+		t.Helper()
+
+		got, err := buildContextHash(dir, dockerfile)
+		if err != nil {
+			t.Fatalf("buildContextHash() failed: %v", err)
+		}
+
+		return got
+	}
+
+	initial := hash()
+
+	if got := hash(); got != initial {
+		t.Errorf("Hash isn't stable: %q != %q", got, initial)
+	}
+
+	testutil.MustWriteFile(t, filepath.Join(dir, "debug.log"), "ignored")
+
+	if got := hash(); got != initial {
+		t.Errorf("Hash changed after writing ignored file")
+	}
+
+	testutil.MustWriteFile(t, filepath.Join(dir, "src", "main.go"), "package main\n\nfunc main() {}\n")
+
+	modified := hash()
+
+	if modified == initial {
+		t.Errorf("Hash unchanged after modifying context")
+	}
+
+	testutil.MustWriteFile(t, dockerfile, "FROM debian\n")
+
+	if got := hash(); got == modified {
+		t.Errorf("Hash unchanged after modifying Dockerfile")
+	}
+}
+
+func TestBuildImageTag(t *testing.T) {
+	for _, tc := range []struct {
+		projectDir string
+		want       string
+	}{
+		{projectDir: "/src/project", want: "cocoon-project:0123456789abcdef"},
+		{projectDir: "/src/My_Project..v2-", want: "cocoon-my-project-v2:0123456789abcdef"},
+		{projectDir: "/", want: "cocoon:0123456789abcdef"},
+	} {
+		t.Run(tc.projectDir, func(t *testing.T) {
+			got := buildImageTag(tc.projectDir, "0123456789abcdef0123")
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("buildImageTag() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProgramBuildPaths(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		buildContext   string
+		dockerfile     string
+		wantContext    string
+		wantDockerfile string
+	}{
+		{
+			name:           "context",
+			buildContext:   "/src",
+			wantContext:    "/src",
+			wantDockerfile: "/src/Dockerfile",
+		},
+		{
+			name:           "dockerfile",
+			dockerfile:     "/src/docker/Dockerfile.dev",
+			wantContext:    "/src/docker",
+			wantDockerfile: "/src/docker/Dockerfile.dev",
+		},
+		{
+			name:           "both",
+			buildContext:   "/src",
+			dockerfile:     "/src/docker/Dockerfile.dev",
+			wantContext:    "/src",
+			wantDockerfile: "/src/docker/Dockerfile.dev",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newProgram()
+			p.buildContext = tc.buildContext
+			p.dockerfile = tc.dockerfile
+
+			gotContext, gotDockerfile := p.buildPaths()
+
+			if diff := cmp.Diff(tc.wantContext, gotContext); diff != "" {
+				t.Errorf("Build context diff (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.wantDockerfile, gotDockerfile); diff != "" {
+				t.Errorf("Dockerfile diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProgramBuildImage(t *testing.T) {
+	tmpdir := t.TempDir()
+	log := filepath.Join(tmpdir, "log")
+	images := filepath.Join(tmpdir, "images")
+
+	// Fake CLI remembering built images.
+	docker := testutil.MustWriteExecutable(t, filepath.Join(tmpdir, "docker"), `#!/bin/sh
+echo "$*" >> '`+log+`'
+case "$1" in
+image)
+	grep -qxF "$4" '`+images+`' 2>/dev/null
+	;;
+build)
+	echo "Step 1/1"
+	echo "${3#--tag=}" >> '`+images+`'
+	;;
+esac
+`)
+
+	contextDir := filepath.Join(tmpdir, "project")
+
+	if err := os.Mkdir(contextDir, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	dockerfile := testutil.MustWriteFile(t, filepath.Join(contextDir, "Dockerfile"), "FROM alpine\n")
+
+	var stdout, stderr strings.Builder
+
+	p := newProgram()
+	p.stdout = &stdout
+	p.stderr = &stderr
+	p.containerEngine = string(engineDocker)
+	p.dockerCliProgram = docker
+	p.projectDir = contextDir
+	p.buildContext = contextDir
+
+	var tags []string
+
+	for range 2 {
+		tag, err := p.buildImage(context.Background())
+		if err != nil {
+			t.Fatalf("buildImage() failed: %v", err)
+		}
+
+		tags = append(tags, tag)
+	}
+
+	if tags[0] != tags[1] || !strings.HasPrefix(tags[0], "cocoon-project:") {
+		t.Errorf("buildImage() returned unexpected tags %q", tags)
+	}
+
+	content, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inspect := "image inspect --format={{.Id}} " + tags[0]
+
+	if diff := cmp.Diff([]string{
+		inspect,
+		"build --file=" + dockerfile + " --tag=" + tags[0] + " --label=" + projectLabel + "=" + contextDir + " " + contextDir,
+		inspect,
+	}, strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")); diff != "" {
+		t.Errorf("CLI invocation diff (-want +got):\n%s", diff)
+	}
+
+	if stdout.Len() > 0 {
+		t.Errorf("Build wrote to standard output: %q", stdout.String())
+	}
+
+	if !strings.Contains(stderr.String(), "Step 1/1") {
+		t.Errorf("Build output missing from standard error: %q", stderr.String())
+	}
+}
+
+func TestProgramBuildImageFailure(t *testing.T) {
+	docker := testutil.MustWriteExecutable(t, filepath.Join(t.TempDir(), "docker"), "#!/bin/sh\nexit 125\n")
+
+	p := newProgram()
+	p.stderr = &strings.Builder{}
+	p.containerEngine = string(engineDocker)
+	p.dockerCliProgram = docker
+	p.dockerfile = testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "Dockerfile"), "FROM alpine\n")
+
+	_, err := p.buildImage(context.Background())
+
+	if err == nil {
+		t.Fatalf("buildImage() succeeded")
+	}
+
+	var cmdErr *commandError
+
+	if errors.As(err, &cmdErr) {
+		t.Errorf("Build failure reported as command exit status: %v", err)
+	}
+}
+
+func TestWalkBuildContext(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, i := range []string{
+		"main.go",
+		"node_modules/pkg/index.js",
+		".git/config",
+		"build/output",
+		"build/keep/file",
+		"vendor/lib.go",
+	} {
+		path := filepath.Join(dir, i)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+
+		testutil.MustWriteFile(t, path, "")
+	}
+
+	testutil.MustWriteFile(t, filepath.Join(dir, dockerignoreFileName), "node_modules\n.git\nbuild\n!build/keep\n")
+
+	patterns, err := readDockerignore(dir)
+	if err != nil {
+		t.Fatalf("readDockerignore() failed: %v", err)
+	}
+
+	var visited []string
+
+	if err := walkBuildContext(dir, patterns, func(_, rel string, _ fs.DirEntry) error {
+		visited = append(visited, rel)
+		return nil
+	}); err != nil {
+		t.Fatalf("walkBuildContext() failed: %v", err)
+	}
+
+	if diff := cmp.Diff([]string{
+		dockerignoreFileName,
+		"build/keep",
+		"build/keep/file",
+		"main.go",
+		"vendor",
+		"vendor/lib.go",
+	}, visited); diff != "" {
+		t.Errorf("Visited entries diff (-want +got):\n%s", diff)
+	}
+
+	for _, tc := range []struct {
+		dir  string
+		want bool
+	}{
+		{dir: "node_modules"},
+		{dir: ".git"},
+		{dir: "build", want: true},
+		{dir: "build/keep"},
+	} {
+		if got := dockerignoreMayReinclude(patterns, tc.dir); got != tc.want {
+			t.Errorf("dockerignoreMayReinclude(%q) returned %t, want %t", tc.dir, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/kballard/go-shellquote"
+)
+
+// Search path used when the environment doesn't define one. Container images
+// usually set it in their configuration.
+const bwrapDefaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+var errBwrapRootfsMissing = errors.New("root filesystem required for bubblewrap")
+
+// bwrapBackend runs commands using bubblewrap in an extracted root
+// filesystem. No container daemon is required.
+type bwrapBackend struct {
+	program string
+	rootfs  string
+
+	uid int
+	gid int
+
+	// Supplementary groups of the invoking process.
+	groups []int
+
+	lookupEnv func(string) (string, bool)
+}
+
+var _ containerBackend = (*bwrapBackend)(nil)
+
+func newBwrapBackend(program, rootfs string) *bwrapBackend {
+	b := &bwrapBackend{
+		program:   program,
+		rootfs:    rootfs,
+		uid:       os.Getuid(),
+		gid:       os.Getgid(),
+		lookupEnv: os.LookupEnv,
+	}
+
+	// Failures only affect the validation of supplementary groups.
+	b.groups, _ = os.Getgroups()
+
+	return b
+}
+
+// resolveEnviron replaces pass-through variables with their local values.
+// Undefined variables are omitted.
+func (b *bwrapBackend) resolveEnviron(environ envMap) map[string]string {
+	result := map[string]string{}
+
+	for variable, value := range environ {
+		if value != nil {
+			result[variable] = *value
+		} else if local, ok := b.lookupEnv(variable); ok {
+			result[variable] = local
+		}
+	}
+
+	if _, ok := result["PATH"]; !ok {
+		result["PATH"] = bwrapDefaultPath
+	}
+
+	return result
+}
+
+// userFlags returns the flags for running as a different user. Bubblewrap
+// requires numeric IDs.
+func (b *bwrapBackend) userFlags(user, group string) ([]string, error) {
+	uid, err := strconv.Atoi(user)
+	if err != nil {
+		return nil, fmt.Errorf("user must be numeric: %w", err)
+	}
+
+	gid, err := strconv.Atoi(group)
+	if err != nil {
+		return nil, fmt.Errorf("group must be numeric: %w", err)
+	}
+
+	if uid == b.uid && gid == b.gid {
+		return nil, nil
+	}
+
+	return []string{
+		"--unshare-user",
+		"--uid", strconv.Itoa(uid),
+		"--gid", strconv.Itoa(gid),
+	}, nil
+}
+
+// checkGroups verifies that all supplementary groups are retained from the
+// invoking process. Bubblewrap can't add groups.
+func (b *bwrapBackend) checkGroups(groups []string) error {
+	for _, g := range groups {
+		if gid, err := strconv.Atoi(g); err != nil || !slices.Contains(b.groups, gid) {
+			return fmt.Errorf("bubblewrap only retains supplementary groups of the local user, can't add %q", g)
+		}
+	}
+
+	return nil
+}
+
+// prepare fails if the specification contains volumes. Bubblewrap has no
+// concept of managed volumes.
+func (*bwrapBackend) prepare(_ context.Context, spec *runSpec) error {
+	if len(spec.volumes) > 0 {
+		return errors.New("named volumes not supported by bubblewrap")
+	}
+
+	return nil
+}
+
+func (b *bwrapBackend) command(_ *runtime, spec *runSpec) ([]string, error) {
+	if b.rootfs == "" {
+		return nil, errBwrapRootfsMissing
+	}
+
+	rootfs, err := prepareRootfs(b.rootfs)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{
+		b.program,
+		"--die-with-parent",
+
+		// Changes, including missing mount points, are written to a
+		// temporary overlay and discarded. The root filesystem directory is
+		// never modified. In read-only mode the root is remounted after all
+		// mounts are in place.
+		"--overlay-src", rootfs, "--tmp-overlay", "/",
+
+		"--dev", "/dev",
+		"--proc", "/proc",
+	}
+
+	userFlags, err := b.userFlags(spec.user, spec.group)
+	if err != nil {
+		return nil, err
+	}
+
+	args = append(args, userFlags...)
+
+	if err := b.checkGroups(slices.Concat(spec.forwardedGroups, spec.groups)); err != nil {
+		return nil, err
+	}
+
+	// Bubblewrap applies mounts in order. Parent directories must be mounted
+	// before their children, e.g. "/tmp" before sockets stored below it.
+	type mountOp struct {
+		path  string
+		flags []string
+	}
+
+	var ops []mountOp
+
+	for _, i := range spec.tmpfs {
+		var flags []string
+
+		if i.noexec {
+			return nil, fmt.Errorf("tmpfs at %s: noexec not supported by bubblewrap", i.path)
+		}
+
+		if i.size > 0 {
+			flags = append(flags, "--size", strconv.FormatInt(i.size, 10))
+		}
+
+		if i.mode != 0 {
+			flags = append(flags, "--perms", fmt.Sprintf("%04o", i.mode))
+		}
+
+		ops = append(ops, mountOp{i.path, append(flags, "--tmpfs", i.path)})
+	}
+
+	for _, i := range spec.mounts {
+		flag := "--ro-bind"
+
+		if i.mode == mountReadWrite {
+			flag = "--bind"
+		}
+
+		ops = append(ops, mountOp{i.dst, []string{flag, i.src, i.dst}})
+	}
+
+	slices.SortStableFunc(ops, func(a, b mountOp) int {
+		return comparePaths(a.path, b.path)
+	})
+
+	for _, i := range ops {
+		args = append(args, i.flags...)
+	}
+
+	if spec.readOnly {
+		args = append(args, "--remount-ro", "/")
+	}
+
+	args = append(args, "--clearenv")
+
+	env := b.resolveEnviron(spec.env)
+
+	for _, variable := range slices.Sorted(maps.Keys(env)) {
+		args = append(args, "--setenv", variable, env[variable])
+	}
+
+	if !spec.tty {
+		// Prevent injecting input into the controlling terminal.
+		args = append(args, "--new-session")
+	}
+
+	args = append(args, "--chdir", spec.workdir, "--", spec.entrypoint)
+	args = append(args, spec.args...)
+
+	return args, nil
+}
+
+// readImageFiles reads files from the root filesystem. The image name is
+// ignored.
+func (b *bwrapBackend) readImageFiles(_ context.Context, _ string, paths []string) (map[string][]byte, error) {
+	if b.rootfs == "" {
+		return nil, errBwrapRootfsMissing
+	}
+
+	rootfs, err := prepareRootfs(b.rootfs)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := os.OpenRoot(rootfs)
+	if err != nil {
+		return nil, err
+	}
+
+	defer root.Close()
+
+	result := map[string][]byte{}
+
+	for _, path := range paths {
+		content, err := root.ReadFile(strings.TrimPrefix(path, "/"))
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		result[path] = content
+	}
+
+	return result, nil
+}
+
+// writeEnviron writes the variables as shell-quoted assignments. Pass-through
+// variables are written by name only.
+func (*bwrapBackend) writeEnviron(w io.Writer, environ envMap) error {
+	for _, variable := range slices.Sorted(maps.Keys(environ)) {
+		line := variable
+
+		if value := environ[variable]; value != nil {
+			line = shellquote.Join(variable + "=" + *value)
+		}
+
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// isRuntimeFailure always returns false. Bubblewrap reports setup failures
+// with status 1 which can't be distinguished from the command's status.
+func (*bwrapBackend) isRuntimeFailure(int) bool {
+	return false
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/hansmi/cocoon/internal/ref"
+)
+
+func TestBwrapBackendCommand(t *testing.T) {
+	rootfs := t.TempDir()
+
+	newBackend := func() *bwrapBackend {
+		b := newBwrapBackend("bwrap", rootfs)
+		b.uid = 1000
+		b.gid = 100
+		b.groups = []int{100, 969}
+		b.lookupEnv = func(name string) (string, bool) {
+			if name == "HOME" {
+				return "/home/user", true
+			}
+
+			return "", false
+		}
+
+		return b
+	}
+
+	for _, tc := range []struct {
+		name    string
+		backend *bwrapBackend
+		spec    runSpec
+		want    []string
+		wantErr error
+	}{
+		{
+			name:    "missing rootfs",
+			backend: newBwrapBackend("bwrap", ""),
+			wantErr: errBwrapRootfsMissing,
+		},
+		{
+			name:    "read-only",
+			backend: newBackend(),
+			spec: runSpec{
+				user:       "1000",
+				group:      "100",
+				groups:     []string{"969"},
+				workdir:    "/home/user/src",
+				readOnly:   true,
+				entrypoint: "make",
+				args:       []string{"all"},
+				mounts: []bindMount{
+					{src: "/home/user", dst: "/home/user", mode: mountReadOnly},
+					{src: "/tmp/cocoon/socket", dst: "/tmp/cocoon/socket", mode: mountReadOnly},
+					{src: "/home/user/src", dst: "/home/user/src", mode: mountReadWrite},
+					{src: "/opt/toolchain-1.2", dst: "/opt/toolchain", mode: mountReadOnly},
+				},
+				tmpfs: []tmpfsMount{
+					{path: "/tmp"},
+					{path: "/home/user/.cache", size: 1024, mode: 0o700},
+				},
+				env: envMap{
+					"HOME":  nil,
+					"UNSET": nil,
+					"FOO":   ref.Ref("bar"),
+				},
+			},
+			want: []string{
+				"bwrap",
+				"--die-with-parent",
+				"--overlay-src", rootfs, "--tmp-overlay", "/",
+				"--dev", "/dev",
+				"--proc", "/proc",
+				"--tmpfs", "/tmp",
+				"--ro-bind", "/home/user", "/home/user",
+				"--ro-bind", "/opt/toolchain-1.2", "/opt/toolchain",
+				"--size", "1024", "--perms", "0700", "--tmpfs", "/home/user/.cache",
+				"--bind", "/home/user/src", "/home/user/src",
+				"--ro-bind", "/tmp/cocoon/socket", "/tmp/cocoon/socket",
+				"--remount-ro", "/",
+				"--clearenv",
+				"--setenv", "FOO", "bar",
+				"--setenv", "HOME", "/home/user",
+				"--setenv", "PATH", bwrapDefaultPath,
+				"--new-session",
+				"--chdir", "/home/user/src",
+				"--", "make", "all",
+			},
+		},
+		{
+			name:    "writable with different user",
+			backend: newBackend(),
+			spec: runSpec{
+				user:       "0",
+				group:      "0",
+				workdir:    "/",
+				tty:        true,
+				entrypoint: "/bin/sh",
+				env: envMap{
+					"PATH": ref.Ref("/bin"),
+				},
+			},
+			want: []string{
+				"bwrap",
+				"--die-with-parent",
+				"--overlay-src", rootfs, "--tmp-overlay", "/",
+				"--dev", "/dev",
+				"--proc", "/proc",
+				"--unshare-user", "--uid", "0", "--gid", "0",
+				"--clearenv",
+				"--setenv", "PATH", "/bin",
+				"--chdir", "/",
+				"--", "/bin/sh",
+			},
+		},
+		{
+			name:    "noexec",
+			backend: newBackend(),
+			spec: runSpec{
+				user:  "1000",
+				group: "100",
+				tmpfs: []tmpfsMount{
+					{path: "/tmp", noexec: true},
+				},
+			},
+			wantErr: cmpopts.AnyError,
+		},
+		{
+			name:    "user name",
+			backend: newBackend(),
+			spec: runSpec{
+				user:  "root",
+				group: "0",
+			},
+			wantErr: cmpopts.AnyError,
+		},
+		{
+			name:    "group name",
+			backend: newBackend(),
+			spec: runSpec{
+				user:   "1000",
+				group:  "100",
+				groups: []string{"postgres"},
+			},
+			wantErr: cmpopts.AnyError,
+		},
+		{
+			name:    "group not retained",
+			backend: newBackend(),
+			spec: runSpec{
+				user:   "1000",
+				group:  "100",
+				groups: []string{"999"},
+			},
+			wantErr: cmpopts.AnyError,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.backend.command(nil, &tc.spec)
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("command() error diff (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("command() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBwrapBackendWriteEnviron(t *testing.T) {
+	var buf strings.Builder
+
+	if err := newBwrapBackend("bwrap", "").writeEnviron(&buf, envMap{
+		"foo":  ref.Ref("bar"),
+		"nl":   ref.Ref("a\nb"),
+		"pass": nil,
+	}); err != nil {
+		t.Errorf("writeEnviron() failed: %v", err)
+	}
+
+	if diff := cmp.Diff("foo=bar\n'nl=a\nb'\npass\n", buf.String()); diff != "" {
+		t.Errorf("writeEnviron() diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestBwrapBackendPrepare(t *testing.T) {
+	b := newBwrapBackend("bwrap", "/rootfs")
+
+	if err := b.prepare(context.Background(), &runSpec{}); err != nil {
+		t.Errorf("prepare() failed: %v", err)
+	}
+
+	if err := b.prepare(context.Background(), &runSpec{
+		volumes: []volumeMount{{name: "cache", dst: "/cache"}},
+	}); err == nil {
+		t.Errorf("prepare() succeeded with volumes")
+	}
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+const (
+	configFileName     = ".cocoon.yaml"
+	defaultProfileName = "default"
+)
+
+// Settings which can be stored in a configuration file. Unset fields leave
+// the corresponding program setting unmodified.
+type configSettings struct {
+	Runtime          *string  `yaml:"runtime"`
+	Image            *string  `yaml:"image"`
+	Pull             *string  `yaml:"pull"`
+	Persistent       *bool    `yaml:"persistent"`
+	Rootfs           *string  `yaml:"rootfs"`
+	BuildContext     *string  `yaml:"build_context"`
+	Dockerfile       *string  `yaml:"dockerfile"`
+	Mounts           []string `yaml:"mounts"`
+	MountsRW         []string `yaml:"mounts_rw"`
+	Tmpfs            []string `yaml:"tmpfs"`
+	MountTmp         *bool    `yaml:"mount_tmp"`
+	Volumes          []string `yaml:"volumes"`
+	EnvFiles         []string `yaml:"env_files"`
+	Env              envMap   `yaml:"env"`
+	Shell            *string  `yaml:"shell"`
+	ReadOnly         *bool    `yaml:"read_only"`
+	SynthesizePasswd *bool    `yaml:"synthesize_passwd"`
+	ForwardGroups    *bool    `yaml:"forward_groups"`
+	GroupFilter      []string `yaml:"group_filter"`
+	GroupAdd         []string `yaml:"group_add"`
+	ForwardSSHAgent  *bool    `yaml:"forward_ssh_agent"`
+	SSHAgentKeys     []string `yaml:"ssh_agent_keys"`
+	SSHAgentConfirm  *bool    `yaml:"ssh_agent_confirm"`
+	ForwardGPGAgent  *bool    `yaml:"forward_gpg_agent"`
+	ForwardX11       *bool    `yaml:"forward_x11"`
+	ForwardWayland   *bool    `yaml:"forward_wayland"`
+	ForwardAudio     *bool    `yaml:"forward_audio"`
+	ForwardDBus      *bool    `yaml:"forward_dbus"`
+	DBusTalk         []string `yaml:"dbus_talk"`
+	DBusOwn          []string `yaml:"dbus_own"`
+	DBusSee          []string `yaml:"dbus_see"`
+	DBusCall         []string `yaml:"dbus_call"`
+	DBusPresets      []string `yaml:"dbus_presets"`
+
+	ForwardSystemDBus *bool    `yaml:"forward_system_dbus"`
+	SystemDBusTalk    []string `yaml:"system_dbus_talk"`
+	SystemDBusOwn     []string `yaml:"system_dbus_own"`
+	SystemDBusSee     []string `yaml:"system_dbus_see"`
+	SystemDBusCall    []string `yaml:"system_dbus_call"`
+	ForwardLocale     *bool    `yaml:"forward_locale"`
+	StopTimeout       *string  `yaml:"stop_timeout"`
+}
+
+// merge overlays the settings from another set. Scalar values are replaced
+// while lists and environment variables are combined.
+func (s *configSettings) merge(other *configSettings) {
+	for _, i := range []struct {
+		value  *string
+		target **string
+	}{
+		{other.Runtime, &s.Runtime},
+		{other.Image, &s.Image},
+		{other.Pull, &s.Pull},
+		{other.Rootfs, &s.Rootfs},
+		{other.BuildContext, &s.BuildContext},
+		{other.Dockerfile, &s.Dockerfile},
+		{other.Shell, &s.Shell},
+		{other.StopTimeout, &s.StopTimeout},
+	} {
+		if i.value != nil {
+			*i.target = i.value
+		}
+	}
+
+	for _, i := range []struct {
+		value  *bool
+		target **bool
+	}{
+		{other.Persistent, &s.Persistent},
+		{other.ReadOnly, &s.ReadOnly},
+		{other.SynthesizePasswd, &s.SynthesizePasswd},
+		{other.ForwardGroups, &s.ForwardGroups},
+		{other.MountTmp, &s.MountTmp},
+		{other.ForwardSSHAgent, &s.ForwardSSHAgent},
+		{other.SSHAgentConfirm, &s.SSHAgentConfirm},
+		{other.ForwardGPGAgent, &s.ForwardGPGAgent},
+		{other.ForwardX11, &s.ForwardX11},
+		{other.ForwardWayland, &s.ForwardWayland},
+		{other.ForwardAudio, &s.ForwardAudio},
+		{other.ForwardDBus, &s.ForwardDBus},
+		{other.ForwardSystemDBus, &s.ForwardSystemDBus},
+		{other.ForwardLocale, &s.ForwardLocale},
+	} {
+		if i.value != nil {
+			*i.target = i.value
+		}
+	}
+
+	s.Mounts = append(s.Mounts, other.Mounts...)
+	s.MountsRW = append(s.MountsRW, other.MountsRW...)
+	s.Tmpfs = append(s.Tmpfs, other.Tmpfs...)
+	s.Volumes = append(s.Volumes, other.Volumes...)
+	s.GroupFilter = append(s.GroupFilter, other.GroupFilter...)
+	s.GroupAdd = append(s.GroupAdd, other.GroupAdd...)
+	s.SSHAgentKeys = append(s.SSHAgentKeys, other.SSHAgentKeys...)
+	s.DBusTalk = append(s.DBusTalk, other.DBusTalk...)
+	s.DBusOwn = append(s.DBusOwn, other.DBusOwn...)
+	s.DBusSee = append(s.DBusSee, other.DBusSee...)
+	s.DBusCall = append(s.DBusCall, other.DBusCall...)
+	s.DBusPresets = append(s.DBusPresets, other.DBusPresets...)
+	s.SystemDBusTalk = append(s.SystemDBusTalk, other.SystemDBusTalk...)
+	s.SystemDBusOwn = append(s.SystemDBusOwn, other.SystemDBusOwn...)
+	s.SystemDBusSee = append(s.SystemDBusSee, other.SystemDBusSee...)
+	s.SystemDBusCall = append(s.SystemDBusCall, other.SystemDBusCall...)
+	s.EnvFiles = append(s.EnvFiles, other.EnvFiles...)
+
+	if len(other.Env) > 0 {
+		if s.Env == nil {
+			s.Env = envMap{}
+		}
+
+		maps.Copy(s.Env, other.Env)
+	}
+}
+
+type configProfile struct {
+	configSettings `yaml:",inline"`
+
+	// Name of another profile whose settings are inherited.
+	Extends string `yaml:"extends"`
+}
+
+type config struct {
+	// Settings shared by all profiles.
+	configSettings `yaml:",inline"`
+
+	Profiles map[string]*configProfile `yaml:"profiles"`
+
+	// Directory containing the configuration file. Relative paths are
+	// resolved against it.
+	baseDir string
+}
+
+func readConfigFile(path string) (*config, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &config{
+		baseDir: filepath.Dir(path),
+	}
+
+	if err := readYAMLFile("configuration", path, cfg); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// findConfigFile looks for a configuration file in the given directory and
+// its parents. The search stops at the filesystem root or at the top-level
+// directory of a Git repository, whichever comes first. An empty string is
+// returned if no configuration file is found.
+func findConfigFile(dir string) (string, error) {
+	for {
+		path := filepath.Join(dir, configFileName)
+
+		if ok, err := fileExists(path); err != nil {
+			return "", err
+		} else if ok {
+			return path, nil
+		}
+
+		if ok, err := fileExists(filepath.Join(dir, ".git")); err != nil {
+			return "", err
+		} else if ok {
+			break
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+
+		dir = parent
+	}
+
+	return "", nil
+}
+
+// explicitFlags returns the names of all flags given on the command line or
+// through their environment variable.
+func explicitFlags(app *kingpin.Application, c *kingpin.ParseContext) map[string]bool {
+	result := map[string]bool{}
+
+	for _, element := range c.Elements {
+		if flag, ok := element.Clause.(*kingpin.FlagClause); ok {
+			result[flag.Model().Name] = true
+		}
+	}
+
+	for _, flag := range app.Model().Flags {
+		if flag.Envar != "" && os.Getenv(flag.Envar) != "" {
+			result[flag.Name] = true
+		}
+	}
+
+	return result
+}
+
+// resolve returns the shared settings combined with those of the named
+// profile and the profiles it extends. An empty name selects the default
+// profile if it's defined.
+func (c *config) resolve(name string) (*configSettings, error) {
+	result := &configSettings{}
+	result.merge(&c.configSettings)
+
+	if name == "" {
+		if _, ok := c.Profiles[defaultProfileName]; !ok {
+			return result, nil
+		}
+
+		name = defaultProfileName
+	}
+
+	var chain []*configProfile
+
+	seen := map[string]bool{}
+
+	for current, parent := name, ""; current != ""; {
+		profile, ok := c.Profiles[current]
+		if !ok {
+			if parent == "" {
+				return nil, fmt.Errorf("unknown profile %q (available: %s)", current, c.profileNames())
+			}
+
+			return nil, fmt.Errorf("profile %q extends unknown profile %q", parent, current)
+		}
+
+		if seen[current] {
+			return nil, fmt.Errorf("profile %q: cyclic inheritance via %q", name, current)
+		}
+
+		seen[current] = true
+
+		if profile == nil {
+			profile = &configProfile{}
+		}
+
+		chain = append(chain, profile)
+
+		current, parent = profile.Extends, current
+	}
+
+	// Apply the most generic profile first.
+	for _, profile := range slices.Backward(chain) {
+		result.merge(&profile.configSettings)
+	}
+
+	return result, nil
+}
+
+// images returns the image references of the shared settings and all
+// profiles.
+func (c *config) images() ([]string, error) {
+	var result []string
+
+	if c.Image != nil {
+		result = append(result, *c.Image)
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(c.Profiles)) {
+		settings, err := c.resolve(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if settings.Image != nil {
+			result = append(result, *settings.Image)
+		}
+	}
+
+	return result, nil
+}
+
+func (c *config) profileNames() string {
+	if len(c.Profiles) == 0 {
+		return "none"
+	}
+
+	return strings.Join(slices.Sorted(maps.Keys(c.Profiles)), ", ")
+}
+
+func resolveConfigPath(baseDir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(baseDir, path)
+}
+
+// apply copies the configured settings to the program. Settings given
+// explicitly via flags take precedence. Mounts, environment files and
+// variables are merged with those from flags. Relative paths are resolved
+// against the base directory.
+func (s *configSettings) apply(p *program, baseDir string, explicit map[string]bool) error {
+	if s.Runtime != nil && !slices.Contains(containerEngineNames, *s.Runtime) {
+		return fmt.Errorf("unsupported container runtime %q", *s.Runtime)
+	}
+
+	if s.Pull != nil && !slices.Contains(pullPolicyNames, *s.Pull) {
+		return fmt.Errorf("unsupported pull policy %q, available: %s", *s.Pull, strings.Join(pullPolicyNames, ", "))
+	}
+
+	for flag, i := range map[string]struct {
+		value  *string
+		target *string
+	}{
+		"runtime": {s.Runtime, &p.containerEngine},
+		"image":   {s.Image, &p.image},
+		"pull":    {s.Pull, &p.pullPolicy},
+		"shell":   {s.Shell, &p.shell},
+	} {
+		if i.value != nil && !explicit[flag] {
+			*i.target = *i.value
+		}
+	}
+
+	for flag, i := range map[string]struct {
+		value  *bool
+		target *bool
+	}{
+		"persistent":          {s.Persistent, &p.persistent},
+		"read-only":           {s.ReadOnly, &p.readOnly},
+		"synthesize-passwd":   {s.SynthesizePasswd, &p.synthesizePasswd},
+		"forward-groups":      {s.ForwardGroups, &p.forwardGroups},
+		"mount-tmp":           {s.MountTmp, &p.mountTmp},
+		"forward-ssh-agent":   {s.ForwardSSHAgent, &p.forwardSSHAgent},
+		"ssh-agent-confirm":   {s.SSHAgentConfirm, &p.sshAgentConfirm},
+		"forward-gpg-agent":   {s.ForwardGPGAgent, &p.forwardGPGAgent},
+		"forward-x11":         {s.ForwardX11, &p.forwardX11},
+		"forward-wayland":     {s.ForwardWayland, &p.forwardWayland},
+		"forward-audio":       {s.ForwardAudio, &p.forwardAudio},
+		"forward-dbus":        {s.ForwardDBus, &p.forwardDBus},
+		"forward-system-dbus": {s.ForwardSystemDBus, &p.forwardSystemDBus},
+		"forward-locale":      {s.ForwardLocale, &p.forwardLocale},
+	} {
+		if i.value != nil && !explicit[flag] {
+			*i.target = *i.value
+		}
+	}
+
+	if s.StopTimeout != nil && !explicit["stop-timeout"] {
+		timeout, err := time.ParseDuration(*s.StopTimeout)
+		if err != nil {
+			return fmt.Errorf("stop timeout: %w", err)
+		}
+
+		p.stopTimeout = timeout
+	}
+
+	for flag, i := range map[string]struct {
+		value  *string
+		target *string
+	}{
+		"rootfs":        {s.Rootfs, &p.rootfs},
+		"build-context": {s.BuildContext, &p.buildContext},
+		"dockerfile":    {s.Dockerfile, &p.dockerfile},
+	} {
+		if i.value != nil && !explicit[flag] {
+			*i.target = resolveConfigPath(baseDir, *i.value)
+		}
+	}
+
+	for _, i := range []struct {
+		specs []string
+		mode  mountMode
+	}{
+		{s.Mounts, mountReadOnly},
+		{s.MountsRW, mountReadWrite},
+	} {
+		for _, spec := range i.specs {
+			m, err := parseMountSpec(spec, i.mode)
+			if err != nil {
+				return err
+			}
+
+			if m.src == m.dst {
+				// Mount at the same location.
+				m.dst = resolveConfigPath(baseDir, m.dst)
+			}
+
+			m.src = resolveConfigPath(baseDir, m.src)
+
+			if origin := p.mounts.origin(m.dst); origin == mountOriginFlag || origin == mountOriginEnvVar {
+				// Flags and environment variables take precedence.
+				continue
+			}
+
+			if err := p.mounts.add(m, mountOriginConfig); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, spec := range s.Tmpfs {
+		m, err := parseTmpfsSpec(spec)
+		if err != nil {
+			return err
+		}
+
+		// Entries present already were given via flags or environment
+		// variables and take precedence.
+		if !p.tmpfs.has(m.path) {
+			p.tmpfs.set(m)
+		}
+	}
+
+	for _, spec := range s.Volumes {
+		v, err := parseVolumeSpec(spec)
+		if err != nil {
+			return err
+		}
+
+		// Volumes present already were given via flags or environment
+		// variables and take precedence.
+		if !p.volumes.has(v.dst) {
+			p.volumes.set(v)
+		}
+	}
+
+	p.groupFilter = append(slices.Clone(s.GroupFilter), p.groupFilter...)
+	p.groupAdd = append(slices.Clone(s.GroupAdd), p.groupAdd...)
+	p.sshAgentKeys = append(slices.Clone(s.SSHAgentKeys), p.sshAgentKeys...)
+
+	p.dbusPolicy.merge(dbusPolicy{
+		talk: s.DBusTalk,
+		own:  s.DBusOwn,
+		see:  s.DBusSee,
+		call: s.DBusCall,
+	})
+
+	p.systemDBusPolicy.merge(dbusPolicy{
+		talk: s.SystemDBusTalk,
+		own:  s.SystemDBusOwn,
+		see:  s.SystemDBusSee,
+		call: s.SystemDBusCall,
+	})
+
+	for _, name := range s.DBusPresets {
+		if _, ok := dbusPresets[name]; !ok {
+			return fmt.Errorf("unknown D-Bus preset %q, available: %s", name, strings.Join(dbusPresetNames, ", "))
+		}
+	}
+
+	p.dbusPresets = append(p.dbusPresets, s.DBusPresets...)
+
+	var envFiles []string
+
+	for _, path := range s.EnvFiles {
+		envFiles = append(envFiles, resolveConfigPath(baseDir, path))
+	}
+
+	// Files given on the command line are read last to allow overriding
+	// values.
+	p.envFiles = append(envFiles, p.envFiles...)
+
+	if p.configEnv == nil {
+		p.configEnv = envMap{}
+	}
+
+	for variable, value := range s.Env {
+		p.configEnv[variable] = value
+	}
+
+	return nil
+}
+
+// loadConfig reads the configuration file, if any, and applies its settings
+// to the program.
+func (p *program) loadConfig(explicit map[string]bool) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("getting working directory: %w", err)
+	}
+
+	path := p.configFile
+
+	if path == "" {
+		if path, err = findConfigFile(cwd); err != nil {
+			return err
+		}
+	}
+
+	p.projectDir = cwd
+
+	if path != "" {
+		// Relative paths within the file and the project directory must not
+		// depend on how the file was named.
+		if path, err = filepath.Abs(path); err != nil {
+			return fmt.Errorf("configuration file: %w", err)
+		}
+
+		p.projectDir = filepath.Dir(path)
+
+		cfg, err := readConfigFile(path)
+		if err != nil {
+			return err
+		}
+
+		settings, err := cfg.resolve(p.profile)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		if err := settings.apply(p, cfg.baseDir, explicit); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		if p.configImages, err = cfg.images(); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	} else if p.profile != "" {
+		return fmt.Errorf("profile %q selected without a configuration file", p.profile)
+	}
+
+	return nil
+}
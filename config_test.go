@@ -0,0 +1,613 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/hansmi/cocoon/internal/ref"
+	"github.com/hansmi/cocoon/internal/testutil"
+)
+
+func TestFindConfigFile(t *testing.T) {
+	root := t.TempDir()
+
+	for _, dir := range []string{
+		"repo/.git",
+		"repo/sub/dir",
+		"plain/sub",
+	} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testutil.MustWriteFile(t, filepath.Join(root, configFileName), "")
+	testutil.MustWriteFile(t, filepath.Join(root, "repo/sub", configFileName), "")
+
+	for _, tc := range []struct {
+		name string
+		dir  string
+		want string
+	}{
+		{
+			name: "same directory",
+			dir:  "repo/sub",
+			want: "repo/sub/" + configFileName,
+		},
+		{
+			name: "parent",
+			dir:  "repo/sub/dir",
+			want: "repo/sub/" + configFileName,
+		},
+		{
+			name: "stop at repository root",
+			dir:  "repo",
+		},
+		{
+			name: "outside repository",
+			dir:  "plain/sub",
+			want: configFileName,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := findConfigFile(filepath.Join(root, tc.dir))
+			if err != nil {
+				t.Errorf("findConfigFile() failed: %v", err)
+			}
+
+			want := tc.want
+
+			if want != "" {
+				want = filepath.Join(root, want)
+			}
+
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("findConfigFile() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReadConfigFile(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		want    configSettings
+		wantErr error
+	}{
+		{name: "empty"},
+		{
+			name: "settings",
+			content: `
+runtime: podman
+image: docker.io/library/debian:stable
+persistent: true
+pull: daily
+rootfs: rootfs.tar
+mounts: [/srv]
+mounts_rw: [cache]
+tmpfs: [/var/tmp]
+mount_tmp: false
+volumes: ["gomod:/go/pkg/mod"]
+env_files: [env.yaml]
+env:
+  FOO: bar
+  PASS: ~
+shell: /bin/bash
+read_only: false
+synthesize_passwd: true
+forward_groups: true
+group_filter: [docker]
+group_add: [postgres]
+forward_ssh_agent: false
+forward_gpg_agent: true
+forward_dbus: true
+forward_locale: true
+stop_timeout: 30s
+`,
+			want: configSettings{
+				Runtime:    ref.Ref("podman"),
+				Image:      ref.Ref("docker.io/library/debian:stable"),
+				Persistent: ref.Ref(true),
+				Pull:       ref.Ref("daily"),
+				Rootfs:     ref.Ref("rootfs.tar"),
+				Mounts:     []string{"/srv"},
+				MountsRW:   []string{"cache"},
+				Tmpfs:      []string{"/var/tmp"},
+				MountTmp:   ref.Ref(false),
+				Volumes:    []string{"gomod:/go/pkg/mod"},
+				EnvFiles:   []string{"env.yaml"},
+				Env: envMap{
+					"FOO":  ref.Ref("bar"),
+					"PASS": nil,
+				},
+				Shell:            ref.Ref("/bin/bash"),
+				ReadOnly:         ref.Ref(false),
+				SynthesizePasswd: ref.Ref(true),
+				ForwardGroups:    ref.Ref(true),
+				GroupFilter:      []string{"docker"},
+				GroupAdd:         []string{"postgres"},
+				ForwardSSHAgent:  ref.Ref(false),
+				ForwardGPGAgent:  ref.Ref(true),
+				ForwardDBus:      ref.Ref(true),
+				ForwardLocale:    ref.Ref(true),
+				StopTimeout:      ref.Ref("30s"),
+			},
+		},
+		{
+			name:    "unknown field",
+			content: "unknown: 1\n",
+			wantErr: cmpopts.AnyError,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := testutil.MustWriteFile(t, filepath.Join(t.TempDir(), configFileName), tc.content)
+
+			got, err := readConfigFile(path)
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("readConfigFile() error diff (-want +got):\n%s", diff)
+			}
+
+			if err == nil {
+				if diff := cmp.Diff(tc.want, got.configSettings, cmpopts.EquateEmpty()); diff != "" {
+					t.Errorf("readConfigFile() diff (-want +got):\n%s", diff)
+				}
+			}
+		})
+	}
+}
+
+func TestReadConfigFileMissing(t *testing.T) {
+	_, err := readConfigFile(filepath.Join(t.TempDir(), "missing"))
+
+	if diff := cmp.Diff(fs.ErrNotExist, err, cmpopts.EquateErrors()); diff != "" {
+		t.Errorf("readConfigFile() error diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestConfigApply(t *testing.T) {
+	settings := &configSettings{
+		Image:       ref.Ref("config-image"),
+		Rootfs:      ref.Ref("rootfs"),
+		Dockerfile:  ref.Ref("Dockerfile.dev"),
+		Mounts:      []string{"/srv", "data", "tools:/opt/tools"},
+		MountsRW:    []string{"/var/cache"},
+		EnvFiles:    []string{"env.yaml"},
+		Env:         envMap{"FOO": ref.Ref("bar")},
+		Shell:       ref.Ref("/bin/zsh"),
+		ReadOnly:    ref.Ref(false),
+		ForwardDBus: ref.Ref(true),
+		StopTimeout: ref.Ref("1m"),
+	}
+
+	p := newProgram()
+	p.image = "flag-image"
+	p.shell = "/bin/sh"
+	p.readOnly = true
+	p.envFiles = []string{"/flag/env.yaml"}
+
+	if err := settings.apply(p, "/project", map[string]bool{
+		"image": true,
+	}); err != nil {
+		t.Errorf("apply() failed: %v", err)
+	}
+
+	if diff := cmp.Diff(&program{
+		x11SocketDir: x11SocketDir,
+		image:        "flag-image",
+		rootfs:       "/project/rootfs",
+		dockerfile:   "/project/Dockerfile.dev",
+		shell:        "/bin/zsh",
+		forwardDBus:  true,
+		stopTimeout:  time.Minute,
+		envFiles:     []string{"/project/env.yaml", "/flag/env.yaml"},
+		configEnv:    envMap{"FOO": ref.Ref("bar")},
+	}, p, cmp.AllowUnexported(program{}, dbusPolicy{}), cmpopts.IgnoreFields(program{}, "stdin", "stdout", "stderr", "mounts", "tmpfs", "volumes")); diff != "" {
+		t.Errorf("Program diff (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff([]bindMount{
+		{src: "/srv", dst: "/srv", mode: mountReadOnly},
+		{src: "/project/tools", dst: "/opt/tools", mode: mountReadOnly},
+		{src: "/project/data", dst: "/project/data", mode: mountReadOnly},
+		{src: "/var/cache", dst: "/var/cache", mode: mountReadWrite},
+	}, p.mounts.list(), cmp.AllowUnexported(bindMount{})); diff != "" {
+		t.Errorf("Mount list diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestConfigApplyTmpfs(t *testing.T) {
+	p := newProgram()
+
+	if err := (&configSettings{
+		Tmpfs: []string{"/run:size=16m"},
+	}).apply(p, "/project", nil); err != nil {
+		t.Errorf("apply() failed: %v", err)
+	}
+
+	if diff := cmp.Diff([]tmpfsMount{
+		{path: "/run", size: 16 << 20},
+	}, p.tmpfs.list(), cmp.AllowUnexported(tmpfsMount{})); diff != "" {
+		t.Errorf("Tmpfs list diff (-want +got):\n%s", diff)
+	}
+
+	if err := (&configSettings{
+		Tmpfs: []string{"relative"},
+	}).apply(p, "/project", nil); err == nil {
+		t.Errorf("apply() succeeded with invalid tmpfs")
+	}
+}
+
+func TestConfigApplyTmpfsPrecedence(t *testing.T) {
+	p := newProgram()
+
+	app := kingpin.New(t.Name(), "")
+
+	tmpfsSetVar(app.Flag("tmpfs", ""), p.tmpfs)
+
+	if _, err := app.Parse([]string{"--tmpfs=/tmp:size=1g"}); err != nil {
+		t.Fatalf("Parsing flags failed: %v", err)
+	}
+
+	if err := (&configSettings{
+		Tmpfs: []string{"/tmp:size=1m", "/run"},
+	}).apply(p, "/project", nil); err != nil {
+		t.Fatalf("apply() failed: %v", err)
+	}
+
+	if diff := cmp.Diff([]tmpfsMount{
+		{path: "/run"},
+		{path: "/tmp", size: 1 << 30},
+	}, p.tmpfs.list(), cmp.AllowUnexported(tmpfsMount{})); diff != "" {
+		t.Errorf("Tmpfs list diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestConfigApplyVolumes(t *testing.T) {
+	p := newProgram()
+
+	if err := (&configSettings{
+		Volumes: []string{"gomod:/go/pkg/mod", "shared:/shared:ro"},
+	}).apply(p, "/project", nil); err != nil {
+		t.Errorf("apply() failed: %v", err)
+	}
+
+	if diff := cmp.Diff([]volumeMount{
+		{name: "shared", dst: "/shared", mode: mountReadOnly},
+		{name: "gomod", dst: "/go/pkg/mod", mode: mountReadWrite},
+	}, p.volumes.list(), cmp.AllowUnexported(volumeMount{})); diff != "" {
+		t.Errorf("Volume list diff (-want +got):\n%s", diff)
+	}
+
+	if err := (&configSettings{
+		Volumes: []string{"/host:/shared"},
+	}).apply(p, "/project", nil); err == nil {
+		t.Errorf("apply() succeeded with invalid volume")
+	}
+}
+
+func TestConfigApplyStopTimeout(t *testing.T) {
+	p := newProgram()
+
+	if err := (&configSettings{
+		StopTimeout: ref.Ref("1m30s"),
+	}).apply(p, "/project", nil); err != nil {
+		t.Errorf("apply() failed: %v", err)
+	} else if want := 90 * time.Second; p.stopTimeout != want {
+		t.Errorf("Stop timeout is %v, want %v", p.stopTimeout, want)
+	}
+
+	if err := (&configSettings{
+		StopTimeout: ref.Ref("soon"),
+	}).apply(p, "/project", nil); err == nil {
+		t.Errorf("apply() succeeded with invalid stop timeout")
+	}
+}
+
+func TestConfigApplyPull(t *testing.T) {
+	p := newProgram()
+
+	if err := (&configSettings{
+		Pull: ref.Ref("never"),
+	}).apply(p, "/project", nil); err != nil {
+		t.Errorf("apply() failed: %v", err)
+	} else if p.pullPolicy != "never" {
+		t.Errorf("Pull policy is %q, want %q", p.pullPolicy, "never")
+	}
+
+	if err := (&configSettings{
+		Pull: ref.Ref("sometimes"),
+	}).apply(p, "/project", nil); err == nil {
+		t.Errorf("apply() succeeded with invalid pull policy")
+	}
+}
+
+func TestConfigImages(t *testing.T) {
+	cfg := &config{
+		configSettings: configSettings{
+			Image: ref.Ref("golang:1.22"),
+		},
+		Profiles: map[string]*configProfile{
+			"debug": {
+				configSettings: configSettings{Image: ref.Ref("golang:1.22-debug")},
+			},
+			"release": {
+				Extends: "debug",
+			},
+			"lint": nil,
+		},
+	}
+
+	got, err := cfg.images()
+	if err != nil {
+		t.Errorf("images() failed: %v", err)
+	}
+
+	if diff := cmp.Diff([]string{
+		"golang:1.22",
+		"golang:1.22-debug",
+		"golang:1.22",
+		"golang:1.22-debug",
+	}, got); diff != "" {
+		t.Errorf("images() diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestConfigResolve(t *testing.T) {
+	cfg := &config{
+		configSettings: configSettings{
+			Image:  ref.Ref("shared"),
+			Mounts: []string{"/shared"},
+			Env:    envMap{"SHARED": ref.Ref("1")},
+		},
+		Profiles: map[string]*configProfile{
+			"base": {
+				configSettings: configSettings{
+					Mounts:          []string{"/base"},
+					Env:             envMap{"BASE": ref.Ref("1")},
+					ForwardSSHAgent: ref.Ref(false),
+				},
+			},
+			"build": {
+				configSettings: configSettings{
+					Image:  ref.Ref("build"),
+					Mounts: []string{"/build"},
+					Env:    envMap{"BASE": ref.Ref("2")},
+				},
+				Extends: "base",
+			},
+			"empty": nil,
+			"loop1": {Extends: "loop2"},
+			"loop2": {Extends: "loop1"},
+			"broken": {
+				Extends: "missing",
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		name    string
+		cfg     *config
+		profile string
+		want    *configSettings
+		wantErr error
+	}{
+		{
+			name: "no profiles",
+			cfg:  &config{},
+			want: &configSettings{},
+		},
+		{
+			name: "shared only",
+			cfg:  cfg,
+			want: &configSettings{
+				Image:  ref.Ref("shared"),
+				Mounts: []string{"/shared"},
+				Env:    envMap{"SHARED": ref.Ref("1")},
+			},
+		},
+		{
+			name: "default",
+			cfg: &config{
+				configSettings: configSettings{
+					Image: ref.Ref("shared"),
+				},
+				Profiles: map[string]*configProfile{
+					defaultProfileName: {
+						configSettings: configSettings{
+							Shell: ref.Ref("/bin/bash"),
+						},
+					},
+				},
+			},
+			want: &configSettings{
+				Image: ref.Ref("shared"),
+				Shell: ref.Ref("/bin/bash"),
+			},
+		},
+		{
+			name:    "extends",
+			cfg:     cfg,
+			profile: "build",
+			want: &configSettings{
+				Image:  ref.Ref("build"),
+				Mounts: []string{"/shared", "/base", "/build"},
+				Env: envMap{
+					"SHARED": ref.Ref("1"),
+					"BASE":   ref.Ref("2"),
+				},
+				ForwardSSHAgent: ref.Ref(false),
+			},
+		},
+		{
+			name:    "empty profile",
+			cfg:     cfg,
+			profile: "empty",
+			want: &configSettings{
+				Image:  ref.Ref("shared"),
+				Mounts: []string{"/shared"},
+				Env:    envMap{"SHARED": ref.Ref("1")},
+			},
+		},
+		{
+			name:    "unknown",
+			cfg:     cfg,
+			profile: "missing",
+			wantErr: cmpopts.AnyError,
+		},
+		{
+			name:    "unknown parent",
+			cfg:     cfg,
+			profile: "broken",
+			wantErr: cmpopts.AnyError,
+		},
+		{
+			name:    "cycle",
+			cfg:     cfg,
+			profile: "loop1",
+			wantErr: cmpopts.AnyError,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.cfg.resolve(tc.profile)
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("resolve() error diff (-want +got):\n%s", diff)
+			}
+
+			if err == nil {
+				if diff := cmp.Diff(tc.want, got, cmpopts.EquateEmpty()); diff != "" {
+					t.Errorf("resolve() diff (-want +got):\n%s", diff)
+				}
+			}
+		})
+	}
+
+	if got := cfg.Profiles["base"].Mounts; len(got) != 1 {
+		t.Errorf("Profile was modified: %q", got)
+	}
+}
+
+func TestExplicitFlags(t *testing.T) {
+	t.Setenv("TEST_COCOON_SECOND", "value")
+	t.Setenv("TEST_COCOON_THIRD", "")
+
+	app := kingpin.New(t.Name(), "")
+	app.Flag("first", "").String()
+	app.Flag("second", "").Envar("TEST_COCOON_SECOND").String()
+	app.Flag("third", "").Envar("TEST_COCOON_THIRD").String()
+	app.Flag("fourth", "").Default("x").String()
+
+	var got map[string]bool
+
+	app.Action(func(c *kingpin.ParseContext) error {
+		got = explicitFlags(app, c)
+		return nil
+	})
+
+	if _, err := app.Parse([]string{"--first=1"}); err != nil {
+		t.Errorf("Parse() failed: %v", err)
+	}
+
+	if diff := cmp.Diff(map[string]bool{
+		"first":  true,
+		"second": true,
+	}, got); diff != "" {
+		t.Errorf("explicitFlags() diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestProgramLoadConfigRelativePath(t *testing.T) {
+	dir := t.TempDir()
+
+	testutil.MustWriteFile(t, filepath.Join(dir, "sub.yaml"), "mounts_rw: [cache]\n")
+
+	t.Chdir(dir)
+
+	p := newProgram()
+	p.configFile = "sub.yaml"
+
+	if err := p.loadConfig(nil); err != nil {
+		t.Fatalf("loadConfig() failed: %v", err)
+	}
+
+	if diff := cmp.Diff(dir, p.projectDir); diff != "" {
+		t.Errorf("Project directory diff (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(filepath.Join(dir, lockFileName), p.lockFilePath()); diff != "" {
+		t.Errorf("Lock file path diff (-want +got):\n%s", diff)
+	}
+
+	cache := filepath.Join(dir, "cache")
+
+	if diff := cmp.Diff([]bindMount{
+		{src: cache, dst: cache, mode: mountReadWrite},
+	}, p.mounts.list(), cmp.AllowUnexported(bindMount{})); diff != "" {
+		t.Errorf("Mount list diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestConfigApplyMountPrecedence(t *testing.T) {
+	t.Setenv("COCOON_TEST_MOUNT", "/srv/env:/env")
+
+	p := newProgram()
+	p.mounts.set("/etc/hosts", mountReadOnly, mountOriginDefault)
+
+	app := kingpin.New(t.Name(), "")
+
+	mountSetVar(app.Flag("ro", "").Envar("COCOON_TEST_MOUNT"), p.mounts, mountReadOnly)
+	mountSetVar(app.Flag("rw", ""), p.mounts, mountReadWrite)
+
+	if _, err := app.Parse([]string{"--rw=/tmp/x:/data"}); err != nil {
+		t.Fatalf("Parsing flags failed: %v", err)
+	}
+
+	if err := (&configSettings{
+		Mounts:   []string{"/tmp/y:/data", "/srv/config:/env", "/srv/hosts:/etc/hosts"},
+		MountsRW: []string{"/srv/extra"},
+	}).apply(p, "/project", nil); err != nil {
+		t.Fatalf("apply() failed: %v", err)
+	}
+
+	if diff := cmp.Diff([]bindMount{
+		{src: "/tmp/x", dst: "/data", mode: mountReadWrite},
+		{src: "/srv/env", dst: "/env", mode: mountReadOnly},
+		{src: "/srv/hosts", dst: "/etc/hosts", mode: mountReadOnly},
+		{src: "/srv/extra", dst: "/srv/extra", mode: mountReadWrite},
+	}, p.mounts.list(), cmp.AllowUnexported(bindMount{})); diff != "" {
+		t.Errorf("Mount list diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestConfigApplyVolumePrecedence(t *testing.T) {
+	p := newProgram()
+
+	app := kingpin.New(t.Name(), "")
+
+	volumeSetVar(app.Flag("volume", ""), p.volumes)
+
+	if _, err := app.Parse([]string{"--volume=clivol:/vol"}); err != nil {
+		t.Fatalf("Parsing flags failed: %v", err)
+	}
+
+	if err := (&configSettings{
+		Volumes: []string{"cfgvol:/vol", "gomod:/go/pkg/mod"},
+	}).apply(p, "/project", nil); err != nil {
+		t.Fatalf("apply() failed: %v", err)
+	}
+
+	if diff := cmp.Diff([]volumeMount{
+		{name: "clivol", dst: "/vol", mode: mountReadWrite},
+		{name: "gomod", dst: "/go/pkg/mod", mode: mountReadWrite},
+	}, p.volumes.list(), cmp.AllowUnexported(volumeMount{})); diff != "" {
+		t.Errorf("Volume list diff (-want +got):\n%s", diff)
+	}
+}
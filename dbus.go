@@ -4,20 +4,150 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strings"
 	"time"
 )
 
-const dbusSessionBusAddressEnv = "DBUS_SESSION_BUS_ADDRESS"
+const (
+	dbusSessionBusAddressEnv    = "DBUS_SESSION_BUS_ADDRESS"
+	dbusSystemBusAddressEnv     = "DBUS_SYSTEM_BUS_ADDRESS"
+	dbusDefaultSystemBusAddress = "unix:path=/run/dbus/system_bus_socket"
+)
+
+// Access rules for the D-Bus proxy. See the "--talk", "--own", "--see" and
+// "--call" options of xdg-dbus-proxy.
+type dbusPolicy struct {
+	talk []string
+	own  []string
+	see  []string
+	call []string
+}
+
+// Names of built-in policies.
+const (
+	dbusPresetNotifications = "notifications"
+	dbusPresetSecretService = "secret-service"
+)
+
+var dbusPresets = map[string]dbusPolicy{
+	dbusPresetNotifications: {
+		talk: []string{"org.freedesktop.Notifications"},
+	},
+	dbusPresetSecretService: {
+		talk: []string{"org.freedesktop.secrets"},
+	},
+}
+
+var dbusPresetNames = slices.Sorted(maps.Keys(dbusPresets))
+
+func (p *dbusPolicy) empty() bool {
+	return len(p.talk)+len(p.own)+len(p.see)+len(p.call) == 0
+}
+
+func (p *dbusPolicy) merge(other dbusPolicy) {
+	p.talk = append(p.talk, other.talk...)
+	p.own = append(p.own, other.own...)
+	p.see = append(p.see, other.see...)
+	p.call = append(p.call, other.call...)
+}
+
+// args returns the proxy options implementing the policy. Without rules the
+// bus is passed through unfiltered.
+func (p *dbusPolicy) args() ([]string, error) {
+	if p.empty() {
+		return nil, nil
+	}
+
+	args := []string{"--filter"}
+
+	for _, i := range []struct {
+		option string
+		values []string
+	}{
+		{"--see", p.see},
+		{"--talk", p.talk},
+		{"--own", p.own},
+		{"--call", p.call},
+	} {
+		for _, value := range i.values {
+			if value == "" {
+				return nil, fmt.Errorf("empty name for %s", i.option)
+			}
+
+			if i.option == "--call" {
+				if name, rule, ok := strings.Cut(value, "="); !ok || name == "" || rule == "" {
+					return nil, fmt.Errorf("call rule must be in the form NAME=RULE: %q", value)
+				}
+			}
+
+			args = append(args, i.option+"="+value)
+		}
+	}
+
+	return args, nil
+}
+
+// sessionBusPolicy returns the rules given directly combined with those of
+// the selected presets.
+func (p *program) sessionBusPolicy() (dbusPolicy, error) {
+	result := dbusPolicy{}
+	result.merge(p.dbusPolicy)
+
+	for _, name := range p.dbusPresets {
+		preset, ok := dbusPresets[name]
+		if !ok {
+			return dbusPolicy{}, fmt.Errorf("unknown D-Bus preset %q, available: %s", name, strings.Join(dbusPresetNames, ", "))
+		}
 
-func (p *program) startDBusProxy(ctx context.Context, r *runtime) (string, func() error, error) {
-	sessionBusAddress := os.Getenv(dbusSessionBusAddressEnv)
-	if sessionBusAddress == "" {
+		result.merge(preset)
+	}
+
+	return result, nil
+}
+
+// startSessionBusProxy starts a proxy for the session bus named by the
+// DBUS_SESSION_BUS_ADDRESS environment variable.
+func (p *program) startSessionBusProxy(ctx context.Context, r *runtime) (string, func() error, error) {
+	address := os.Getenv(dbusSessionBusAddressEnv)
+	if address == "" {
 		return "", nil, fmt.Errorf("environment variable %q is unset or empty", dbusSessionBusAddressEnv)
 	}
 
+	policy, err := p.sessionBusPolicy()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return p.startDBusProxy(ctx, r, address, policy)
+}
+
+// startSystemBusProxy starts a proxy for the system bus. The well-known
+// socket location is used unless the DBUS_SYSTEM_BUS_ADDRESS environment
+// variable names a different address.
+func (p *program) startSystemBusProxy(ctx context.Context, r *runtime) (string, func() error, error) {
+	address := os.Getenv(dbusSystemBusAddressEnv)
+	if address == "" {
+		address = dbusDefaultSystemBusAddress
+	}
+
+	return p.startDBusProxy(ctx, r, address, p.systemDBusPolicy)
+}
+
+// startDBusProxy launches xdg-dbus-proxy for the bus at the given address and
+// waits for it to become ready. The proxy's socket is created in its own
+// directory within the runtime's base directory. Multiple proxies can run
+// concurrently, each terminated by its cleanup function.
+func (p *program) startDBusProxy(ctx context.Context, r *runtime, address string, policy dbusPolicy) (string, func() error, error) {
+	policyArgs, err := policy.args()
+	if err != nil {
+		return "", nil, err
+	}
+
 	sockDir, err := r.createDir("dbus")
 	if err != nil {
 		return "", nil, err
@@ -25,12 +155,19 @@ func (p *program) startDBusProxy(ctx context.Context, r *runtime) (string, func(
 
 	sock := filepath.Join(sockDir, "socket")
 
+	if p.simulated() {
+		// Only the socket location is needed.
+		return sock, func() error { return nil }, nil
+	}
+
 	pr, pw, err := os.Pipe()
 	if err != nil {
 		return "", nil, err
 	}
 
-	cmd := exec.CommandContext(ctx, p.xdgDBusProxyProgram, "--fd=3", sessionBusAddress, sock)
+	args := append([]string{"--fd=3", address, sock}, policyArgs...)
+
+	cmd := exec.CommandContext(ctx, p.xdgDBusProxyProgram, args...)
 	cmd.Stdout = p.stderr
 	cmd.Stderr = p.stderr
 	cmd.ExtraFiles = append(cmd.ExtraFiles, pw)
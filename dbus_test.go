@@ -3,9 +3,15 @@ package main
 import (
 	"context"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/hansmi/cocoon/internal/testutil"
 )
 
 func TestStartDBusProxy(t *testing.T) {
@@ -30,7 +36,7 @@ func TestStartDBusProxy(t *testing.T) {
 			p.xdgDBusProxyProgram = tc.program
 			p.xdgDBusProxyReadyTimeout = time.Second
 
-			_, _, err := p.startDBusProxy(ctx, &r)
+			_, _, err := p.startSessionBusProxy(ctx, &r)
 
 			if err == nil {
 				t.Errorf("Expected error, got %v", err)
@@ -38,3 +44,258 @@ func TestStartDBusProxy(t *testing.T) {
 		})
 	}
 }
+
+func TestDBusPolicyArgs(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		policy  dbusPolicy
+		want    []string
+		wantErr error
+	}{
+		{name: "empty"},
+		{
+			name: "all",
+			policy: dbusPolicy{
+				talk: []string{"org.example.Talk", "org.example.Other"},
+				own:  []string{"org.example.Own"},
+				see:  []string{"org.example.See"},
+				call: []string{"org.example.Call=org.example.Call.Method@/path"},
+			},
+			want: []string{
+				"--filter",
+				"--see=org.example.See",
+				"--talk=org.example.Talk",
+				"--talk=org.example.Other",
+				"--own=org.example.Own",
+				"--call=org.example.Call=org.example.Call.Method@/path",
+			},
+		},
+		{
+			name:    "empty name",
+			policy:  dbusPolicy{talk: []string{""}},
+			wantErr: cmpopts.AnyError,
+		},
+		{
+			name:    "call without rule",
+			policy:  dbusPolicy{call: []string{"org.example.Call"}},
+			wantErr: cmpopts.AnyError,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.policy.args()
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("args() error diff (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("args() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// writeFakeDBusProxy creates a program signalling readiness like
+// xdg-dbus-proxy. The arguments are recorded next to the socket, see
+// readFakeDBusProxyArgs. It terminates once the notification pipe is closed.
+func writeFakeDBusProxy(t *testing.T) string {
+	t.Helper()
+
+	path := testutil.MustWriteExecutable(t, filepath.Join(t.TempDir(), "xdg-dbus-proxy"), `#!/bin/sh
+trap '' PIPE
+printf '%s\n' "$@" > "$3.args"
+while printf x >&3; do
+	sleep 0.01
+done 2>/dev/null
+exit 0
+`)
+
+	return path
+}
+
+func readFakeDBusProxyArgs(t *testing.T, sock string) []string {
+	t.Helper()
+
+	content, err := os.ReadFile(sock + ".args")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+func TestDBusPolicyEnvar(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	t.Setenv("COCOON_DBUS_TALK", "org.example.A\norg.example.B")
+	t.Setenv("COCOON_DBUS_OWN", "org.example.Own")
+	t.Setenv("COCOON_DBUS_SEE", "org.example.See")
+	t.Setenv("COCOON_DBUS_CALL", "org.example.A=*")
+	t.Setenv("COCOON_SYSTEM_DBUS_TALK", "org.freedesktop.NetworkManager")
+	t.Setenv("COCOON_SYSTEM_DBUS_OWN", "org.example.SystemOwn")
+	t.Setenv("COCOON_SYSTEM_DBUS_SEE", "org.example.SystemSee")
+	t.Setenv("COCOON_SYSTEM_DBUS_CALL", "org.freedesktop.NetworkManager=*")
+
+	p := newProgram()
+
+	if err := p.detectDefaults(); err != nil {
+		t.Fatal(err)
+	}
+
+	app := kingpin.New(t.Name(), "")
+
+	p.registerFlags(app)
+
+	if _, err := app.Parse([]string{"--image=image"}); err != nil {
+		t.Fatalf("Parsing flags failed: %v", err)
+	}
+
+	if diff := cmp.Diff(dbusPolicy{
+		talk: []string{"org.example.A", "org.example.B"},
+		own:  []string{"org.example.Own"},
+		see:  []string{"org.example.See"},
+		call: []string{"org.example.A=*"},
+	}, p.dbusPolicy, cmp.AllowUnexported(dbusPolicy{})); diff != "" {
+		t.Errorf("Session bus policy diff (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(dbusPolicy{
+		talk: []string{"org.freedesktop.NetworkManager"},
+		own:  []string{"org.example.SystemOwn"},
+		see:  []string{"org.example.SystemSee"},
+		call: []string{"org.freedesktop.NetworkManager=*"},
+	}, p.systemDBusPolicy, cmp.AllowUnexported(dbusPolicy{})); diff != "" {
+		t.Errorf("System bus policy diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestStartDBusProxyFilter(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		policy  dbusPolicy
+		presets []string
+		want    []string
+		wantErr error
+	}{
+		{
+			name: "unfiltered",
+		},
+		{
+			name:    "presets",
+			policy:  dbusPolicy{own: []string{"org.example.App"}},
+			presets: []string{dbusPresetNotifications, dbusPresetSecretService},
+			want: []string{
+				"--filter",
+				"--talk=org.freedesktop.Notifications",
+				"--talk=org.freedesktop.secrets",
+				"--own=org.example.App",
+			},
+		},
+		{
+			name:    "unknown preset",
+			presets: []string{"unknown"},
+			wantErr: cmpopts.AnyError,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			t.Cleanup(cancel)
+
+			t.Setenv(dbusSessionBusAddressEnv, "unix:path=/run/user/1000/bus")
+
+			r := &runtime{}
+			t.Cleanup(func() { os.RemoveAll(r.baseDir) })
+
+			p := newProgram()
+			p.xdgDBusProxyProgram = writeFakeDBusProxy(t)
+			p.xdgDBusProxyReadyTimeout = time.Minute
+			p.dbusPolicy = tc.policy
+			p.dbusPresets = tc.presets
+
+			sock, cleanup, err := p.startSessionBusProxy(ctx, r)
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("startDBusProxy() error diff (-want +got):\n%s", diff)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if err := cleanup(); err != nil {
+				t.Errorf("Cleanup failed: %v", err)
+			}
+
+			want := append([]string{"--fd=3", "unix:path=/run/user/1000/bus", sock}, tc.want...)
+
+			if diff := cmp.Diff(want, readFakeDBusProxyArgs(t, sock)); diff != "" {
+				t.Errorf("Proxy arguments diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestStartSessionAndSystemBusProxies(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		systemAddress string
+		wantAddress   string
+	}{
+		{name: "default", wantAddress: dbusDefaultSystemBusAddress},
+		{name: "environment", systemAddress: "unix:path=/custom", wantAddress: "unix:path=/custom"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			t.Cleanup(cancel)
+
+			t.Setenv(dbusSessionBusAddressEnv, "unix:path=/run/user/1000/bus")
+			t.Setenv(dbusSystemBusAddressEnv, tc.systemAddress)
+
+			r := &runtime{}
+			t.Cleanup(func() { os.RemoveAll(r.baseDir) })
+
+			p := newProgram()
+			p.xdgDBusProxyProgram = writeFakeDBusProxy(t)
+			p.xdgDBusProxyReadyTimeout = time.Minute
+			p.dbusPresets = []string{dbusPresetNotifications}
+			p.systemDBusPolicy = dbusPolicy{talk: []string{"org.freedesktop.NetworkManager"}}
+
+			sessionSock, sessionCleanup, err := p.startSessionBusProxy(ctx, r)
+			if err != nil {
+				t.Fatalf("startSessionBusProxy() failed: %v", err)
+			}
+
+			systemSock, systemCleanup, err := p.startSystemBusProxy(ctx, r)
+			if err != nil {
+				t.Fatalf("startSystemBusProxy() failed: %v", err)
+			}
+
+			if sessionSock == systemSock {
+				t.Errorf("Proxies share socket %s", sessionSock)
+			}
+
+			// Proxies are terminated independently.
+			if err := systemCleanup(); err != nil {
+				t.Errorf("System bus cleanup failed: %v", err)
+			}
+
+			if err := sessionCleanup(); err != nil {
+				t.Errorf("Session bus cleanup failed: %v", err)
+			}
+
+			if diff := cmp.Diff([]string{
+				"--fd=3", "unix:path=/run/user/1000/bus", sessionSock,
+				"--filter", "--talk=org.freedesktop.Notifications",
+			}, readFakeDBusProxyArgs(t, sessionSock)); diff != "" {
+				t.Errorf("Session bus proxy arguments diff (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff([]string{
+				"--fd=3", tc.wantAddress, systemSock,
+				"--filter", "--talk=org.freedesktop.NetworkManager",
+			}, readFakeDBusProxyArgs(t, systemSock)); diff != "" {
+				t.Errorf("System bus proxy arguments diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
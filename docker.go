@@ -1,12 +1,14 @@
 package main
 
 import (
+	"archive/tar"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"maps"
-	"os/exec"
 	"slices"
+	"strconv"
 	"strings"
 )
 
@@ -52,52 +54,297 @@ func writeDockerEnviron(w io.Writer, environ envMap) error {
 	return nil
 }
 
-func (p *program) toDockerCommand(envFile string, mounts *mountSet) (_ []string, err error) {
-	dockerCli, err := exec.LookPath(p.dockerCliProgram)
-	if err != nil {
-		return nil, fmt.Errorf("unable to find Docker CLI: %w", err)
+func dockerMountFlags(mounts []bindMount) []string {
+	var result []string
+
+	for _, m := range mounts {
+		value := fmt.Sprintf("--mount=type=bind,src=%s,dst=%s", m.src, m.dst)
+
+		if m.mode != mountReadWrite {
+			value += ",readonly"
+		}
+
+		result = append(result, value)
+	}
+
+	return result
+}
+
+func dockerTmpfsFlags(mounts []tmpfsMount) []string {
+	var result []string
+
+	for _, m := range mounts {
+		options := []string{"rw", "exec"}
+
+		if m.noexec {
+			options[1] = "noexec"
+		}
+
+		if m.size > 0 {
+			options = append(options, fmt.Sprintf("size=%d", m.size))
+		}
+
+		if m.mode != 0 {
+			options = append(options, fmt.Sprintf("mode=%o", m.mode))
+		}
+
+		result = append(result, fmt.Sprintf("--tmpfs=%s:%s", m.path, strings.Join(options, ",")))
+	}
+
+	return result
+}
+
+func dockerVolumeFlags(volumes []volumeMount) []string {
+	var result []string
+
+	for _, v := range volumes {
+		value := fmt.Sprintf("--mount=type=volume,src=%s,dst=%s", v.name, v.dst)
+
+		if v.mode != mountReadWrite {
+			value += ",readonly"
+		}
+
+		result = append(result, value)
 	}
 
-	entrypoint := p.shell
+	return result
+}
+
+type dockerBackend struct {
+	program string
+
+	// Additional flags for the "run" command.
+	extraFlags []string
 
-	if len(p.args) > 0 {
-		entrypoint = p.args[0]
+	// Additional flags for the "volume create" command.
+	volumeCreateFlags []string
+
+	exitCodes []int
+
+	// Host groups can only be forwarded all at once using "keep-groups" as
+	// their IDs aren't mapped within the user namespace.
+	keepGroups bool
+
+	// Supplementary groups of the invoking process.
+	hostGroups []int
+
+	// Run the CLI and return its output.
+	output func(ctx context.Context, args ...string) (string, error)
+}
+
+var _ containerBackend = (*dockerBackend)(nil)
+
+func newDockerBackend(program string) *dockerBackend {
+	return &dockerBackend{
+		program:   program,
+		exitCodes: dockerExitCodes,
+		output: func(ctx context.Context, args ...string) (string, error) {
+			return commandOutput(ctx, program, args...)
+		},
+	}
+}
+
+// prepare creates missing volumes. Labels identify the project for which a
+// volume was created.
+func (b *dockerBackend) prepare(ctx context.Context, spec *runSpec) error {
+	for _, v := range spec.volumes {
+		args := []string{"volume", "create"}
+		args = append(args, b.volumeCreateFlags...)
+		args = append(args, "--label="+projectLabel+"="+spec.projectDir, v.name)
+
+		if _, err := b.output(ctx, args...); err != nil {
+			return fmt.Errorf("creating volume: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// envFlags returns the flags passing the environment to the container.
+func (b *dockerBackend) envFlags(r *runtime, spec *runSpec) ([]string, error) {
+	if spec.inlineEnv {
+		var result []string
+
+		for _, variable := range slices.Sorted(maps.Keys(spec.env)) {
+			if value := spec.env[variable]; value == nil {
+				result = append(result, "--env="+variable)
+			} else {
+				result = append(result, "--env="+variable+"="+*value)
+			}
+		}
+
+		return result, nil
+	}
+
+	envFile, err := createTempEnvFile(r, b, spec.env)
+	if err != nil || envFile == "" {
+		return nil, err
+	}
+
+	return []string{"--env-file=" + envFile}, nil
+}
+
+func (b *dockerBackend) command(r *runtime, spec *runSpec) ([]string, error) {
+	envFlags, err := b.envFlags(r, spec)
+	if err != nil {
+		return nil, err
 	}
 
 	args := []string{
-		dockerCli, "run",
+		b.program, "run",
 
-		"--entrypoint=" + entrypoint,
+		"--entrypoint=" + spec.entrypoint,
 		"--init",
-		"--name=" + p.containerName,
+	}
+
+	if spec.name != "" {
+		args = append(args, "--name="+spec.name)
+	}
+
+	args = append(args,
 		"--network=host",
 		"--pid=host",
 		"--rm",
-		"--user=" + p.user + ":" + p.group,
+		"--user="+spec.user+":"+spec.group,
 		"--uts=host",
-		"--workdir=" + p.workdir,
+		"--workdir="+spec.workdir,
 
-		fmt.Sprintf("--read-only=%t", p.readOnly),
+		fmt.Sprintf("--read-only=%t", spec.readOnly),
+	)
 
-		// TODO: Should there be a "--mount-tmpfs" flag?
-		"--tmpfs=/tmp:rw,exec",
+	if spec.projectDir != "" {
+		args = append(args, "--label="+projectLabel+"="+spec.projectDir)
 	}
 
-	args = append(args, mounts.toDockerFlags()...)
+	args = append(args, dockerLabelFlags(spec.labels)...)
 
-	if p.interactive {
-		args = append(args, "--interactive", "--tty")
+	groupFlags, err := b.groupFlags(spec)
+	if err != nil {
+		return nil, err
 	}
 
-	if envFile != "" {
-		args = append(args, fmt.Sprintf("--env-file=%s", envFile))
+	args = append(args, groupFlags...)
+
+	args = append(args, dockerTmpfsFlags(spec.tmpfs)...)
+	args = append(args, b.extraFlags...)
+	args = append(args, dockerMountFlags(spec.mounts)...)
+	args = append(args, dockerVolumeFlags(spec.volumes)...)
+
+	if spec.tty {
+		args = append(args, "--interactive", "--tty")
 	}
 
-	args = append(args, p.image)
+	args = append(args, envFlags...)
+	args = append(args, spec.image)
+	args = append(args, spec.args...)
+
+	return args, nil
+}
+
+// groupFlags returns the flags for adding supplementary groups.
+func (b *dockerBackend) groupFlags(spec *runSpec) ([]string, error) {
+	var args []string
+
+	if b.keepGroups && len(spec.forwardedGroups) > 0 {
+		var all []string
 
-	if len(p.args) > 1 {
-		args = append(args, p.args[1:]...)
+		for _, gid := range b.hostGroups {
+			if g := strconv.Itoa(gid); g != spec.group && !slices.Contains(all, g) {
+				all = append(all, g)
+			}
+		}
+
+		slices.Sort(all)
+
+		if !slices.Equal(all, slices.Sorted(slices.Values(spec.forwardedGroups))) {
+			return nil, errors.New("rootless Podman can only forward all supplementary groups, a group filter isn't supported")
+		}
+
+		if len(spec.groups) > 0 {
+			return nil, errors.New("rootless Podman can't add groups while forwarding supplementary groups")
+		}
+
+		return []string{"--group-add=keep-groups"}, nil
+	}
+
+	for _, g := range slices.Concat(spec.forwardedGroups, spec.groups) {
+		args = append(args, "--group-add="+g)
 	}
 
 	return args, nil
 }
+
+// readTarFile returns the content of the first entry in a tar archive, e.g.
+// written by the "cp" command. Entries other than regular files, e.g.
+// symlinks, result in nil content.
+func readTarFile(data string) ([]byte, error) {
+	tr := tar.NewReader(strings.NewReader(data))
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	if hdr.Typeflag != tar.TypeReg {
+		return nil, nil
+	}
+
+	return io.ReadAll(tr)
+}
+
+// isMissingFileError reports whether copying a file from a container failed
+// because it doesn't exist. Docker and Podman use different messages.
+func isMissingFileError(err error) bool {
+	msg := strings.ToLower(err.Error())
+
+	return strings.Contains(msg, "no such file") || strings.Contains(msg, "could not find the file")
+}
+
+// readImageFiles copies files from a container created, but not started,
+// from the image.
+func (b *dockerBackend) readImageFiles(ctx context.Context, image string, paths []string) (_ map[string][]byte, err error) {
+	// The entrypoint is never run. Images without a default command can't be
+	// created otherwise.
+	id, err := b.output(ctx, "create", "--entrypoint=/", image)
+	if err != nil {
+		return nil, fmt.Errorf("creating container: %w", err)
+	}
+
+	id = strings.TrimSpace(id)
+
+	defer func() {
+		if _, rmErr := b.output(ctx, "rm", "--force", id); rmErr != nil {
+			err = errors.Join(err, fmt.Errorf("removing container: %w", rmErr))
+		}
+	}()
+
+	result := map[string][]byte{}
+
+	for _, path := range paths {
+		data, err := b.output(ctx, "cp", id+":"+path, "-")
+		if err != nil {
+			if isMissingFileError(err) {
+				continue
+			}
+
+			return nil, fmt.Errorf("copying %s: %w", path, err)
+		}
+
+		content, err := readTarFile(data)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+
+		result[path] = content
+	}
+
+	return result, nil
+}
+
+func (*dockerBackend) writeEnviron(w io.Writer, environ envMap) error {
+	return writeDockerEnviron(w, environ)
+}
+
+func (b *dockerBackend) isRuntimeFailure(status int) bool {
+	return slices.Contains(b.exitCodes, status)
+}
@@ -1,6 +1,12 @@
 package main
 
 import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"slices"
 	"strings"
 	"testing"
 
@@ -57,3 +63,390 @@ func TestWriteDockerEnviron(t *testing.T) {
 		})
 	}
 }
+
+func TestDockerBackendCommand(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		backend *dockerBackend
+		spec    runSpec
+		want    []string
+	}{
+		{
+			name:    "minimal",
+			backend: newDockerBackend("/usr/bin/docker"),
+			spec: runSpec{
+				name:       "test",
+				image:      "alpine",
+				user:       "1000",
+				group:      "100",
+				workdir:    "/src",
+				entrypoint: "/bin/sh",
+			},
+			want: []string{
+				"/usr/bin/docker", "run",
+				"--entrypoint=/bin/sh",
+				"--init",
+				"--name=test",
+				"--network=host",
+				"--pid=host",
+				"--rm",
+				"--user=1000:100",
+				"--uts=host",
+				"--workdir=/src",
+				"--read-only=false",
+				"alpine",
+			},
+		},
+		{
+			name:    "inline environment",
+			backend: newDockerBackend("docker"),
+			spec: runSpec{
+				image:      "alpine",
+				user:       "1000",
+				group:      "100",
+				workdir:    "/src",
+				entrypoint: "/bin/sh",
+				env: envMap{
+					"FOO":  ref.Ref("bar\nbaz"),
+					"HOME": nil,
+				},
+				inlineEnv: true,
+			},
+			want: []string{
+				"docker", "run",
+				"--entrypoint=/bin/sh",
+				"--init",
+				"--network=host",
+				"--pid=host",
+				"--rm",
+				"--user=1000:100",
+				"--uts=host",
+				"--workdir=/src",
+				"--read-only=false",
+				"--env=FOO=bar\nbaz",
+				"--env=HOME",
+				"alpine",
+			},
+		},
+		{
+			name:    "podman",
+			backend: newPodmanBackend("podman", 1000),
+			spec: runSpec{
+				name:       "test",
+				image:      "alpine",
+				user:       "1000",
+				group:      "100",
+				workdir:    "/src",
+				readOnly:   true,
+				projectDir: "/src",
+				labels:     map[string]string{pidLabel: "123", profileLabel: "debug"},
+				groups:     []string{"969", "postgres"},
+				entrypoint: "make",
+				args:       []string{"-j4", "all"},
+				tty:        true,
+				mounts: []bindMount{
+					{src: "/", dst: "/", mode: mountReadOnly},
+					{src: "/src", dst: "/src", mode: mountReadWrite},
+					{src: "/home/user/cache", dst: "/root/.cache", mode: mountReadWrite},
+				},
+				volumes: []volumeMount{
+					{name: "gomod", dst: "/go/pkg/mod", mode: mountReadWrite},
+					{name: "shared", dst: "/shared", mode: mountReadOnly},
+				},
+				tmpfs: []tmpfsMount{
+					{path: "/tmp"},
+					{path: "/var/cache", size: 1 << 20, mode: 0o1777, noexec: true},
+				},
+			},
+			want: []string{
+				"podman", "run",
+				"--entrypoint=make",
+				"--init",
+				"--name=test",
+				"--network=host",
+				"--pid=host",
+				"--rm",
+				"--user=1000:100",
+				"--uts=host",
+				"--workdir=/src",
+				"--read-only=true",
+				"--label=" + projectLabel + "=/src",
+				"--label=" + pidLabel + "=123",
+				"--label=" + profileLabel + "=debug",
+				"--group-add=969",
+				"--group-add=postgres",
+				"--tmpfs=/tmp:rw,exec",
+				"--tmpfs=/var/cache:rw,noexec,size=1048576,mode=1777",
+				"--userns=keep-id",
+				"--mount=type=bind,src=/,dst=/,readonly",
+				"--mount=type=bind,src=/src,dst=/src",
+				"--mount=type=bind,src=/home/user/cache,dst=/root/.cache",
+				"--mount=type=volume,src=gomod,dst=/go/pkg/mod",
+				"--mount=type=volume,src=shared,dst=/shared,readonly",
+				"--interactive",
+				"--tty",
+				"alpine",
+				"-j4",
+				"all",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var r runtime
+
+			t.Cleanup(func() { r.cleanup() })
+
+			got, err := tc.backend.command(&r, &tc.spec)
+			if err != nil {
+				t.Errorf("command() failed: %v", err)
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("command() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDockerBackendEnvFile(t *testing.T) {
+	var r runtime
+
+	t.Cleanup(func() { r.cleanup() })
+
+	got, err := newDockerBackend("docker").command(&r, &runSpec{
+		env: envMap{
+			"foo": ref.Ref("bar"),
+		},
+	})
+	if err != nil {
+		t.Errorf("command() failed: %v", err)
+	}
+
+	var envFile string
+
+	for _, i := range got {
+		if value, ok := strings.CutPrefix(i, "--env-file="); ok {
+			envFile = value
+		}
+	}
+
+	if envFile == "" {
+		t.Fatalf("Missing environment file flag: %q", got)
+	}
+
+	content, err := os.ReadFile(envFile)
+	if err != nil {
+		t.Errorf("Reading environment file failed: %v", err)
+	}
+
+	if diff := cmp.Diff("foo=bar\n", string(content)); diff != "" {
+		t.Errorf("Environment file diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestDockerBackendIsRuntimeFailure(t *testing.T) {
+	b := newDockerBackend("docker")
+
+	for _, status := range []int{0, 1, 2, 124, 128, 255} {
+		if b.isRuntimeFailure(status) {
+			t.Errorf("isRuntimeFailure(%d) returned true", status)
+		}
+	}
+
+	for _, status := range dockerExitCodes {
+		if !b.isRuntimeFailure(status) {
+			t.Errorf("isRuntimeFailure(%d) returned false", status)
+		}
+	}
+}
+
+func TestDockerBackendPrepare(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		backend *dockerBackend
+		want    [][]string
+	}{
+		{
+			name:    "docker",
+			backend: newDockerBackend("docker"),
+			want: [][]string{
+				{"volume", "create", "--label=" + projectLabel + "=/src", "first"},
+				{"volume", "create", "--label=" + projectLabel + "=/src", "second"},
+			},
+		},
+		{
+			name:    "podman",
+			backend: newPodmanBackend("podman", 1000),
+			want: [][]string{
+				{"volume", "create", "--ignore", "--label=" + projectLabel + "=/src", "first"},
+				{"volume", "create", "--ignore", "--label=" + projectLabel + "=/src", "second"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got [][]string
+
+			tc.backend.output = func(_ context.Context, args ...string) (string, error) {
+				got = append(got, args)
+				return "", nil
+			}
+
+			if err := tc.backend.prepare(context.Background(), &runSpec{
+				projectDir: "/src",
+				volumes: []volumeMount{
+					{name: "first", dst: "/a"},
+					{name: "second", dst: "/b"},
+				},
+			}); err != nil {
+				t.Errorf("prepare() failed: %v", err)
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("CLI invocation diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDockerBackendReadImageFiles(t *testing.T) {
+	var archive bytes.Buffer
+
+	tw := tar.NewWriter(&archive)
+
+	if err := tw.WriteHeader(&tar.Header{Name: "passwd", Mode: 0o644, Size: 5}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := tw.Write([]byte("root\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got [][]string
+
+	b := newDockerBackend("docker")
+	b.output = func(_ context.Context, args ...string) (string, error) {
+		got = append(got, args)
+
+		switch {
+		case args[0] == "create":
+			return "abc123\n", nil
+
+		case slices.Equal(args, []string{"cp", "abc123:/etc/passwd", "-"}):
+			return archive.String(), nil
+
+		case args[0] == "cp":
+			return "", errors.New("Error response from daemon: Could not find the file /etc/group in container abc123")
+		}
+
+		return "", nil
+	}
+
+	files, err := b.readImageFiles(context.Background(), "alpine", []string{"/etc/passwd", "/etc/group"})
+	if err != nil {
+		t.Fatalf("readImageFiles() failed: %v", err)
+	}
+
+	if diff := cmp.Diff(map[string][]byte{"/etc/passwd": []byte("root\n")}, files); diff != "" {
+		t.Errorf("readImageFiles() diff (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff([][]string{
+		{"create", "--entrypoint=/", "alpine"},
+		{"cp", "abc123:/etc/passwd", "-"},
+		{"cp", "abc123:/etc/group", "-"},
+		{"rm", "--force", "abc123"},
+	}, got); diff != "" {
+		t.Errorf("CLI invocation diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestDockerBackendGroupFlags(t *testing.T) {
+	podman := newPodmanBackend("podman", 1000)
+	podman.hostGroups = []int{100, 969, 1001, 969}
+
+	for _, tc := range []struct {
+		name    string
+		backend *dockerBackend
+		spec    runSpec
+		want    []string
+		wantErr error
+	}{
+		{
+			name:    "none",
+			backend: podman,
+			spec:    runSpec{group: "100"},
+		},
+		{
+			name:    "docker",
+			backend: newDockerBackend("docker"),
+			spec: runSpec{
+				group:           "100",
+				forwardedGroups: []string{"969"},
+				groups:          []string{"postgres"},
+			},
+			want: []string{"--group-add=969", "--group-add=postgres"},
+		},
+		{
+			name:    "podman added",
+			backend: podman,
+			spec: runSpec{
+				group:  "100",
+				groups: []string{"postgres"},
+			},
+			want: []string{"--group-add=postgres"},
+		},
+		{
+			name:    "podman forwarded",
+			backend: podman,
+			spec: runSpec{
+				group:           "100",
+				forwardedGroups: []string{"1001", "969"},
+			},
+			want: []string{"--group-add=keep-groups"},
+		},
+		{
+			name:    "podman rootful",
+			backend: newPodmanBackend("podman", 0),
+			spec: runSpec{
+				group:           "100",
+				forwardedGroups: []string{"969"},
+			},
+			want: []string{"--group-add=969"},
+		},
+		{
+			name:    "podman filtered",
+			backend: podman,
+			spec: runSpec{
+				group:           "100",
+				forwardedGroups: []string{"969"},
+			},
+			wantErr: cmpopts.AnyError,
+		},
+		{
+			name:    "podman forwarded and added",
+			backend: podman,
+			spec: runSpec{
+				group:           "100",
+				forwardedGroups: []string{"969", "1001"},
+				groups:          []string{"postgres"},
+			},
+			wantErr: cmpopts.AnyError,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tc.backend.groupFlags(&tc.spec)
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("groupFlags() error diff (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("groupFlags() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/kballard/go-shellquote"
+)
+
+// Prefix for labels attached to objects managed by cocoon.
+const labelPrefix = "com.github.hansmi.cocoon."
+
+// Label attached to all containers and volumes created by cocoon. The value
+// is the project directory.
+const projectLabel = labelPrefix + "project"
+
+// sanitizeObjectName replaces characters not permitted in the names of
+// containers and volumes.
+func sanitizeObjectName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune("_.-", r) || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+
+		return '_'
+	}, name)
+}
+
+type containerEngine string
+
+const (
+	engineAuto   containerEngine = "auto"
+	engineDocker containerEngine = "docker"
+	enginePodman containerEngine = "podman"
+	engineBwrap  containerEngine = "bwrap"
+)
+
+var containerEngineNames = []string{
+	string(engineAuto),
+	string(engineDocker),
+	string(enginePodman),
+	string(engineBwrap),
+}
+
+// detectContainerEngine derives the engine from the name of its CLI program.
+// Symlinks are resolved first as some distributions install "docker" as an
+// alias for "podman".
+func detectContainerEngine(path string) containerEngine {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	if strings.HasPrefix(filepath.Base(path), string(enginePodman)) {
+		return enginePodman
+	}
+
+	return engineDocker
+}
+
+type containerCli struct {
+	path   string
+	engine containerEngine
+}
+
+// containerCliProgram returns the CLI program for the given engine. The
+// program defaults to the engine name.
+func containerCliProgram(program string, engine containerEngine) string {
+	if program != "" {
+		return program
+	}
+
+	if engine == engineAuto {
+		return string(engineDocker)
+	}
+
+	return string(engine)
+}
+
+// newContainerCli returns the CLI at the given path. The engine is detected
+// from the program name if necessary.
+func newContainerCli(path string, engine containerEngine) *containerCli {
+	if engine == engineAuto {
+		engine = detectContainerEngine(path)
+	}
+
+	return &containerCli{
+		path:   path,
+		engine: engine,
+	}
+}
+
+// findContainerCli locates the CLI program for the given engine. The program
+// defaults to the engine name.
+func findContainerCli(program string, engine containerEngine) (*containerCli, error) {
+	path, err := exec.LookPath(containerCliProgram(program, engine))
+	if err != nil {
+		return nil, fmt.Errorf("unable to find container runtime CLI: %w", err)
+	}
+
+	return newContainerCli(path, engine), nil
+}
+
+// commandOutput runs a program and returns its standard output. Standard
+// error is included in the error on failure.
+func commandOutput(ctx context.Context, program string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, program, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+
+		return "", fmt.Errorf("%s: %w", shellquote.Join(args...), err)
+	}
+
+	return stdout.String(), nil
+}
+
+// output runs the CLI with the given arguments and returns its standard
+// output.
+func (c *containerCli) output(ctx context.Context, args ...string) (string, error) {
+	return commandOutput(ctx, c.path, args...)
+}
+
+// stream runs the CLI with both of its output streams written to w, e.g. to
+// show progress.
+func (c *containerCli) stream(ctx context.Context, w io.Writer, args ...string) error {
+	cmd := exec.CommandContext(ctx, c.path, args...)
+	cmd.Stdout = w
+	cmd.Stderr = w
+
+	return cmd.Run()
+}
+
+// imageExists reports whether an image is available locally.
+func (c *containerCli) imageExists(ctx context.Context, ref string) bool {
+	_, err := c.output(ctx, "image", "inspect", "--format={{.Id}}", ref)
+
+	return err == nil
+}
+
+// newBackend returns the backend for a container runtime CLI.
+func (c *containerCli) newBackend() *dockerBackend {
+	if c.engine == enginePodman {
+		return newPodmanBackend(c.path, os.Getuid())
+	}
+
+	return newDockerBackend(c.path)
+}
+
+// containerCli locates the CLI of the selected container runtime.
+func (p *program) containerCli() (*containerCli, error) {
+	engine := containerEngine(p.containerEngine)
+
+	if engine == engineBwrap {
+		return nil, fmt.Errorf("operation not supported by the %q runtime", engine)
+	}
+
+	if p.dryRun {
+		// The CLI isn't invoked. Keeping the name makes the printed command
+		// independent of the search path.
+		return newContainerCli(containerCliProgram(p.dockerCliProgram, engine), engine), nil
+	}
+
+	return findContainerCli(p.dockerCliProgram, engine)
+}
+
+// newBackend returns the backend for the selected container runtime.
+func (p *program) newBackend() (containerBackend, error) {
+	if containerEngine(p.containerEngine) == engineBwrap {
+		program := p.bwrapProgram
+
+		// The program isn't invoked in dry-run mode.
+		if !p.dryRun {
+			path, err := exec.LookPath(program)
+			if err != nil {
+				return nil, fmt.Errorf("unable to find bubblewrap: %w", err)
+			}
+
+			program = path
+		}
+
+		return newBwrapBackend(program, p.rootfs), nil
+	}
+
+	if p.image == "" {
+		return nil, fmt.Errorf("image must be specified using --image, COCOON_IMAGE or a %s file", configFileName)
+	}
+
+	cli, err := p.containerCli()
+	if err != nil {
+		return nil, err
+	}
+
+	return cli.newBackend(), nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/hansmi/cocoon/internal/testutil"
+)
+
+func TestFindContainerCli(t *testing.T) {
+	tmpdir := t.TempDir()
+
+	docker := testutil.MustWriteExecutable(t, filepath.Join(tmpdir, "docker"), "#!/bin/sh\n")
+	podman := testutil.MustWriteExecutable(t, filepath.Join(tmpdir, "podman"), "#!/bin/sh\n")
+	podmanRemote := testutil.MustWriteExecutable(t, filepath.Join(tmpdir, "podman-remote"), "#!/bin/sh\n")
+	dockerAlias := filepath.Join(tmpdir, "docker-alias")
+
+	if err := os.Symlink(podman, dockerAlias); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("PATH", tmpdir)
+
+	for _, tc := range []struct {
+		name    string
+		program string
+		engine  containerEngine
+		want    *containerCli
+		wantErr error
+	}{
+		{
+			name:   "auto",
+			engine: engineAuto,
+			want:   &containerCli{path: docker, engine: engineDocker},
+		},
+		{
+			name:   "podman default program",
+			engine: enginePodman,
+			want:   &containerCli{path: podman, engine: enginePodman},
+		},
+		{
+			name:    "detect podman",
+			program: "podman-remote",
+			engine:  engineAuto,
+			want:    &containerCli{path: podmanRemote, engine: enginePodman},
+		},
+		{
+			name:    "detect podman via symlink",
+			program: dockerAlias,
+			engine:  engineAuto,
+			want:    &containerCli{path: dockerAlias, engine: enginePodman},
+		},
+		{
+			name:    "explicit engine",
+			program: podman,
+			engine:  engineDocker,
+			want:    &containerCli{path: podman, engine: engineDocker},
+		},
+		{
+			name:    "missing",
+			program: "missing",
+			engine:  engineAuto,
+			wantErr: cmpopts.AnyError,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := findContainerCli(tc.program, tc.engine)
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("findContainerCli() error diff (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.want, got, cmp.AllowUnexported(containerCli{})); diff != "" {
+				t.Errorf("findContainerCli() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProgramNewBackendBwrap(t *testing.T) {
+	tmpdir := t.TempDir()
+
+	bwrap := testutil.MustWriteExecutable(t, filepath.Join(tmpdir, "bwrap"), "#!/bin/sh\n")
+
+	t.Setenv("PATH", tmpdir)
+
+	for _, tc := range []struct {
+		name    string
+		program string
+		dryRun  bool
+		want    string
+		wantErr error
+	}{
+		{name: "search path", program: "bwrap", want: bwrap},
+		{name: "missing", program: "missing", wantErr: cmpopts.AnyError},
+		{name: "dry-run", program: "missing", dryRun: true, want: "missing"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newProgram()
+			p.containerEngine = string(engineBwrap)
+			p.bwrapProgram = tc.program
+			p.dryRun = tc.dryRun
+
+			got, err := p.newBackend()
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("newBackend() error diff (-want +got):\n%s", diff)
+			}
+
+			if err == nil {
+				if diff := cmp.Diff(tc.want, got.(*bwrapBackend).program); diff != "" {
+					t.Errorf("Program diff (-want +got):\n%s", diff)
+				}
+			}
+		})
+	}
+}
@@ -1,9 +1,10 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
-	"maps"
 	"os"
 	"strings"
 
@@ -12,53 +13,92 @@ import (
 
 type envMap map[string]*string
 
-func readEnvFile(path string) (envMap, error) {
+const (
+	envSourceBase    = "base"
+	envSourceConfig  = "config"
+	envSourceLiteral = "literal"
+)
+
+// Map from variable names to where their values were defined, i.e.
+// envSourceBase, envSourceConfig, envSourceLiteral or the path of an
+// environment file.
+type envSources map[string]string
+
+// readYAMLFile decodes the YAML or JSON document stored in a file. Unknown
+// fields are rejected. An empty file leaves the value unmodified.
+func readYAMLFile(kind, path string, value any) error {
 	fh, err := os.Open(path)
 	if err != nil {
-		return nil, fmt.Errorf("opening environment file %s: %w", path, err)
+		return fmt.Errorf("opening %s file %s: %w", kind, path, err)
 	}
 
 	defer fh.Close()
 
 	raw, err := io.ReadAll(fh)
 	if err != nil {
-		return nil, fmt.Errorf("reading environment file %s: %w", path, err)
+		return fmt.Errorf("reading %s file %s: %w", kind, path, err)
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(raw))
+	dec.KnownFields(true)
+
+	if err := dec.Decode(value); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parsing %s YAML read from %s: %w", kind, path, err)
 	}
 
+	return nil
+}
+
+func readEnvFile(path string) (envMap, error) {
 	var values envMap
 
-	if err := yaml.Unmarshal(raw, &values); err != nil {
-		return nil, fmt.Errorf("parsing environment YAML read from %s: %w", path, err)
+	if err := readYAMLFile("environment", path, &values); err != nil {
+		return nil, err
 	}
 
 	return values, nil
 }
 
-func combineEnviron(base envMap, files []string, literal []string) (envMap, error) {
-	environ := maps.Clone(base)
+// combineEnviron merges the base variables with those from the configuration
+// file, read from files and given literally. Later definitions take
+// precedence.
+func combineEnviron(base, config envMap, files []string, literal []string) (envMap, envSources, error) {
+	environ := envMap{}
+	sources := envSources{}
 
-	if len(base) == 0 {
-		environ = envMap{}
+	for variable, value := range base {
+		environ[variable] = value
+		sources[variable] = envSourceBase
+	}
+
+	for variable, value := range config {
+		environ[variable] = value
+		sources[variable] = envSourceConfig
 	}
 
 	for _, i := range files {
 		entries, err := readEnvFile(i)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		for variable, value := range entries {
 			environ[variable] = value
+			sources[variable] = i
 		}
 	}
 
 	for _, i := range literal {
-		if variable, value, hasValue := strings.Cut(i, "="); hasValue {
+		variable, value, hasValue := strings.Cut(i, "=")
+
+		if hasValue {
 			environ[variable] = &value
 		} else {
 			environ[variable] = nil
 		}
+
+		sources[variable] = envSourceLiteral
 	}
 
-	return environ, nil
+	return environ, sources, nil
 }
@@ -12,27 +12,35 @@ import (
 )
 
 func TestCombineEnviron(t *testing.T) {
+	emptyFile := testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "empty"), "")
+	firstFile := testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "yaml"), "# comment\nfirst: 1\nkey: value\n")
+	secondFile := testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "yaml"), "# comment\nfirst: 2\nunset: ~")
+	mixedFile := testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "yaml"), "---\nfile: b\n")
+
 	for _, tc := range []struct {
-		name    string
-		base    envMap
-		files   []string
-		literal []string
-		want    envMap
-		wantErr error
+		name        string
+		base        envMap
+		config      envMap
+		files       []string
+		literal     []string
+		want        envMap
+		wantSources envSources
+		wantErr     error
 	}{
 		{name: "empty"},
 		{
-			name: "files",
-			files: []string{
-				testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "empty"), ""),
-				testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "yaml"), "# comment\nfirst: 1\nkey: value\n"),
-				testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "yaml"), "# comment\nfirst: 2\nunset: ~"),
-			},
+			name:  "files",
+			files: []string{emptyFile, firstFile, secondFile},
 			want: envMap{
 				"key":   ref.Ref("value"),
 				"first": ref.Ref("2"),
 				"unset": nil,
 			},
+			wantSources: envSources{
+				"key":   firstFile,
+				"first": secondFile,
+				"unset": secondFile,
+			},
 		},
 		{
 			name: "file not found",
@@ -59,16 +67,20 @@ func TestCombineEnviron(t *testing.T) {
 				"x": ref.Ref("hello"),
 				"y": nil,
 			},
+			wantSources: envSources{
+				"a": envSourceLiteral,
+				"b": envSourceLiteral,
+				"c": envSourceLiteral,
+				"x": envSourceBase,
+				"y": envSourceBase,
+			},
 		},
 		{
 			name: "mixed",
 			base: envMap{
 				"base": ref.Ref("a"),
 			},
-			files: []string{
-				testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "empty"), ""),
-				testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "yaml"), "---\nfile: b\n"),
-			},
+			files: []string{emptyFile, mixedFile},
 			literal: []string{
 				"literal=c",
 			},
@@ -77,10 +89,63 @@ func TestCombineEnviron(t *testing.T) {
 				"file":    ref.Ref("b"),
 				"literal": ref.Ref("c"),
 			},
+			wantSources: envSources{
+				"base":    envSourceBase,
+				"file":    mixedFile,
+				"literal": envSourceLiteral,
+			},
+		},
+		{
+			name: "override",
+			base: envMap{
+				"base": ref.Ref("a"),
+				"key":  ref.Ref("base"),
+			},
+			files: []string{firstFile},
+			literal: []string{
+				"key=override",
+			},
+			want: envMap{
+				"base":  ref.Ref("a"),
+				"first": ref.Ref("1"),
+				"key":   ref.Ref("override"),
+			},
+			wantSources: envSources{
+				"base":  envSourceBase,
+				"first": firstFile,
+				"key":   envSourceLiteral,
+			},
+		},
+		{
+			name: "config",
+			base: envMap{
+				"HOME":  nil,
+				"shell": ref.Ref("base"),
+			},
+			config: envMap{
+				"shell": ref.Ref("config"),
+				"first": ref.Ref("config"),
+				"pass":  nil,
+			},
+			files: []string{firstFile},
+			want: envMap{
+				"HOME":  nil,
+				"shell": ref.Ref("config"),
+				"first": ref.Ref("1"),
+				"key":   ref.Ref("value"),
+				"pass":  nil,
+			},
+			wantSources: envSources{
+				"HOME":  envSourceBase,
+				"shell": envSourceConfig,
+				"first": firstFile,
+				"key":   firstFile,
+				"pass":  envSourceConfig,
+			},
 		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
-			got, err := combineEnviron(tc.base, tc.files, tc.literal)
+			got, gotSources, err := combineEnviron(tc.base, tc.config, tc.files, tc.literal)
 
 			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
 				t.Errorf("combineEnviron() error diff (-want +got):\n%s", diff)
@@ -90,6 +155,10 @@ func TestCombineEnviron(t *testing.T) {
 				if diff := cmp.Diff(tc.want, got, cmpopts.EquateEmpty()); diff != "" {
 					t.Errorf("combineEnviron() result diff (-want +got):\n%s", diff)
 				}
+
+				if diff := cmp.Diff(tc.wantSources, gotSources, cmpopts.EquateEmpty()); diff != "" {
+					t.Errorf("combineEnviron() sources diff (-want +got):\n%s", diff)
+				}
 			}
 		})
 	}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// execCommand returns the command line for running a command within a
+// running container.
+func (b *dockerBackend) execCommand(r *runtime, spec *runSpec) ([]string, error) {
+	envFlags, err := b.envFlags(r, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{
+		b.program, "exec",
+		"--user=" + spec.user + ":" + spec.group,
+		"--workdir=" + spec.workdir,
+	}
+
+	if spec.tty {
+		args = append(args, "--interactive", "--tty")
+	}
+
+	args = append(args, envFlags...)
+	args = append(args, spec.name, spec.entrypoint)
+	args = append(args, spec.args...)
+
+	return args, nil
+}
+
+// listContainers returns the sorted names of running containers created by
+// cocoon for a project. An empty project directory selects the containers of
+// all projects.
+func (b *dockerBackend) listContainers(ctx context.Context, projectDir string) ([]string, error) {
+	filter := projectLabel
+
+	if projectDir != "" {
+		filter += "=" + projectDir
+	}
+
+	output, err := b.output(ctx, "ps", "--filter=label="+filter, "--format={{.Names}}")
+	if err != nil {
+		return nil, fmt.Errorf("listing containers: %w", err)
+	}
+
+	return slices.Sorted(slices.Values(strings.Fields(output))), nil
+}
+
+// promptContainer asks the user to choose one of the containers, either by
+// its number or its name.
+func promptContainer(r io.Reader, w io.Writer, names []string) (string, error) {
+	for idx, name := range names {
+		if _, err := fmt.Fprintf(w, "%d) %s\n", idx+1, name); err != nil {
+			return "", err
+		}
+	}
+
+	if _, err := fmt.Fprint(w, "Container: "); err != nil {
+		return "", err
+	}
+
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && (line == "" || !errors.Is(err, io.EOF)) {
+		return "", fmt.Errorf("reading selection: %w", err)
+	}
+
+	choice := strings.TrimSpace(line)
+
+	if idx, err := strconv.Atoi(choice); err == nil && idx >= 1 && idx <= len(names) {
+		return names[idx-1], nil
+	}
+
+	if slices.Contains(names, choice) {
+		return choice, nil
+	}
+
+	return "", fmt.Errorf("invalid selection %q", choice)
+}
+
+// selectContainer picks the container for executing a command. The user is
+// asked to choose if multiple containers are running and standard input is a
+// terminal.
+func (p *program) selectContainer(names []string) (string, error) {
+	switch len(names) {
+	case 0:
+		return "", errors.New("no running cocoon containers found")
+	case 1:
+		return names[0], nil
+	}
+
+	if !isTerminal(p.stdin) {
+		return "", fmt.Errorf("multiple containers running, select one using --container: %s", strings.Join(names, ", "))
+	}
+
+	return promptContainer(p.stdin, p.stderr, names)
+}
+
+// execContainer runs a command or the shell within a running container using
+// the same user, working directory and environment handling as the "run"
+// command. Forwarded sockets and mounts are those of the running container.
+func (p *program) execContainer(ctx context.Context) (err error) {
+	signals, stopSignals := notifyTermination()
+	defer stopSignals()
+
+	cli, err := p.containerCli()
+	if err != nil {
+		return err
+	}
+
+	backend := cli.newBackend()
+
+	name := p.execContainerName
+
+	if name == "" {
+		projectDir := p.projectDir
+
+		if p.execAllProjects {
+			projectDir = ""
+		}
+
+		names, err := backend.listContainers(ctx, projectDir)
+		if err != nil {
+			return err
+		}
+
+		if name, err = p.selectContainer(names); err != nil {
+			return err
+		}
+	}
+
+	p.containerName = name
+
+	r := &runtime{}
+
+	defer func() {
+		if cleanupErr := r.cleanup(); cleanupErr != nil {
+			err = errors.Join(err, fmt.Errorf("cleanup: %w", cleanupErr))
+		}
+	}()
+
+	env, _, err := p.combineEnviron(p.baseEnviron())
+	if err != nil {
+		return err
+	}
+
+	spec, err := p.toRunSpec(newMountSet(), env)
+	if err != nil {
+		return err
+	}
+
+	spec.inlineEnv = p.dryRun
+
+	args, err := backend.execCommand(r, spec)
+	if err != nil {
+		return err
+	}
+
+	if p.dryRun {
+		return p.printDryRun(r, args)
+	}
+
+	return p.runContainerCommand(ctx, backend, args, signals, "")
+}
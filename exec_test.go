@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestDockerBackendExecCommand(t *testing.T) {
+	var r runtime
+
+	t.Cleanup(func() { r.cleanup() })
+
+	got, err := newDockerBackend("docker").execCommand(&r, &runSpec{
+		name:       "test",
+		user:       "1000",
+		group:      "100",
+		workdir:    "/src",
+		tty:        true,
+		entrypoint: "make",
+		args:       []string{"-j4", "all"},
+	})
+	if err != nil {
+		t.Errorf("execCommand() failed: %v", err)
+	}
+
+	if diff := cmp.Diff([]string{
+		"docker", "exec",
+		"--user=1000:100",
+		"--workdir=/src",
+		"--interactive",
+		"--tty",
+		"test",
+		"make",
+		"-j4",
+		"all",
+	}, got); diff != "" {
+		t.Errorf("execCommand() diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestDockerBackendListContainers(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		projectDir string
+		want       []string
+	}{
+		{
+			name:       "project",
+			projectDir: "/src/project",
+			want:       []string{"ps", "--filter=label=" + projectLabel + "=/src/project", "--format={{.Names}}"},
+		},
+		{
+			name: "all",
+			want: []string{"ps", "--filter=label=" + projectLabel, "--format={{.Names}}"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+
+			b := newDockerBackend("docker")
+			b.output = func(_ context.Context, args ...string) (string, error) {
+				got = args
+				return "second\nfirst\n", nil
+			}
+
+			names, err := b.listContainers(context.Background(), tc.projectDir)
+			if err != nil {
+				t.Errorf("listContainers() failed: %v", err)
+			}
+
+			if diff := cmp.Diff([]string{"first", "second"}, names); diff != "" {
+				t.Errorf("listContainers() diff (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("CLI invocation diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPromptContainer(t *testing.T) {
+	names := []string{"first", "second"}
+
+	for _, tc := range []struct {
+		name    string
+		input   string
+		want    string
+		wantErr error
+	}{
+		{name: "number", input: "2\n", want: "second"},
+		{name: "name", input: " first \n", want: "first"},
+		{name: "without newline", input: "1", want: "first"},
+		{name: "out of range", input: "3\n", wantErr: cmpopts.AnyError},
+		{name: "unknown", input: "third\n", wantErr: cmpopts.AnyError},
+		{name: "empty", wantErr: cmpopts.AnyError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var prompt strings.Builder
+
+			got, err := promptContainer(strings.NewReader(tc.input), &prompt, names)
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("promptContainer() error diff (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("promptContainer() diff (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff("1) first\n2) second\nContainer: ", prompt.String()); diff != "" {
+				t.Errorf("Prompt diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProgramSelectContainer(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		names   []string
+		want    string
+		wantErr error
+	}{
+		{name: "none", wantErr: cmpopts.AnyError},
+		{name: "single", names: []string{"first"}, want: "first"},
+		{name: "multiple without terminal", names: []string{"first", "second"}, wantErr: cmpopts.AnyError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newProgram()
+			p.stdin = strings.NewReader("1\n")
+
+			got, err := p.selectContainer(tc.names)
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("selectContainer() error diff (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("selectContainer() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	gpgHomeEnv              = "GNUPGHOME"
+	gpgAgentSocketName      = "S.gpg-agent"
+	gpgAgentExtraSocketName = "S.gpg-agent.extra"
+)
+
+// Names of the public keyring in order of preference. The second is used by
+// GnuPG versions before 2.1.
+var gpgPublicKeyringNames = []string{"pubring.kbx", "pubring.gpg"}
+
+const zbase32Alphabet = "ybndrfg8ejkmcpqxot1uwisza345h769"
+
+// zbase32Encode encodes data using the human-oriented base-32 encoding used by
+// GnuPG. Trailing bits are padded with zeros.
+func zbase32Encode(data []byte) string {
+	var sb strings.Builder
+
+	var buf, bits uint
+
+	for _, b := range data {
+		buf = buf<<8 | uint(b)
+		bits += 8
+
+		for bits >= 5 {
+			bits -= 5
+			sb.WriteByte(zbase32Alphabet[(buf>>bits)&0x1f])
+		}
+	}
+
+	if bits > 0 {
+		sb.WriteByte(zbase32Alphabet[(buf<<(5-bits))&0x1f])
+	}
+
+	return sb.String()
+}
+
+// gpgHomeDir returns the GnuPG home directory and whether it's the default
+// location within the user's home directory.
+func gpgHomeDir(home, gnupgHome string) (string, bool) {
+	defaultDir := filepath.Join(home, ".gnupg")
+
+	if gnupgHome == "" {
+		return defaultDir, true
+	}
+
+	gnupgHome = filepath.Clean(gnupgHome)
+
+	return gnupgHome, gnupgHome == defaultDir
+}
+
+// gpgSocketDir returns the directory containing the agent sockets following
+// the conventions of gpgconf. Home directories other than the default use a
+// subdirectory named after a hash of their path.
+func gpgSocketDir(runtimeDir, homeDir string, isDefault bool) string {
+	dir := filepath.Join(runtimeDir, "gnupg")
+
+	if !isDefault {
+		sum := sha1.Sum([]byte(homeDir))
+		dir = filepath.Join(dir, "d."+zbase32Encode(sum[:15]))
+	}
+
+	return dir
+}
+
+// createMountPoint creates an empty file to be replaced by a bind mount.
+func createMountPoint(path string) error {
+	return os.WriteFile(path, nil, 0o600)
+}
+
+// setupGPGAgent makes the extra socket of the local GnuPG agent and the
+// public keyring available within the container. The extra socket restricts
+// the operations available to remote clients. The container uses a separate
+// GnuPG home directory stored in the runtime's base directory.
+func (p *program) setupGPGAgent(r *runtime, mounts *mountSet, env envMap) error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("getting home dir: %w", err)
+	}
+
+	homeDir, isDefault := gpgHomeDir(home, os.Getenv(gpgHomeEnv))
+
+	runtimeDir := hostRuntimeDir()
+
+	// GnuPG falls back to the home directory when the runtime directory
+	// doesn't exist.
+	candidates := []string{
+		filepath.Join(gpgSocketDir(runtimeDir, homeDir, isDefault), gpgAgentExtraSocketName),
+		filepath.Join(homeDir, gpgAgentExtraSocketName),
+	}
+
+	var socket string
+
+	for _, i := range candidates {
+		if ok, err := fileExists(i); err != nil {
+			return err
+		} else if ok {
+			socket = i
+			break
+		}
+	}
+
+	if socket == "" {
+		log.Printf("Warning: GnuPG agent socket not found at %s, not forwarding agent", strings.Join(candidates, " or "))
+		return nil
+	}
+
+	containerHomeDir, err := r.createDir("gnupg")
+	if err != nil {
+		return err
+	}
+
+	mounts.set(containerHomeDir, mountReadWrite, mountOriginGPGAgent)
+
+	agentSocket := filepath.Join(containerHomeDir, gpgAgentSocketName)
+
+	if err := createMountPoint(agentSocket); err != nil {
+		return err
+	}
+
+	mounts.bind(socket, agentSocket, mountReadOnly, mountOriginGPGAgent)
+
+	for _, name := range gpgPublicKeyringNames {
+		keyring := filepath.Join(homeDir, name)
+
+		if ok, err := fileExists(keyring); err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+
+		dst := filepath.Join(containerHomeDir, name)
+
+		if err := createMountPoint(dst); err != nil {
+			return err
+		}
+
+		mounts.bind(keyring, dst, mountReadOnly, mountOriginGPGAgent)
+
+		break
+	}
+
+	env[gpgHomeEnv] = &containerHomeDir
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hansmi/cocoon/internal/testutil"
+)
+
+func TestZbase32Encode(t *testing.T) {
+	for _, tc := range []struct {
+		data []byte
+		want string
+	}{
+		{},
+		{data: []byte{0}, want: "yy"},
+		{data: []byte{0xf0, 0xbf, 0xc7}, want: "6n9hq"},
+		{data: []byte{0xd4, 0x7a, 0x04}, want: "4t7ye"},
+	} {
+		if got := zbase32Encode(tc.data); got != tc.want {
+			t.Errorf("zbase32Encode(%x) = %q, want %q", tc.data, got, tc.want)
+		}
+	}
+}
+
+func TestGPGSocketDir(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		home      string
+		gnupgHome string
+		want      string
+	}{
+		{
+			name: "default",
+			home: "/home/user",
+			want: "/run/user/1000/gnupg",
+		},
+		{
+			name:      "explicit default",
+			home:      "/home/user",
+			gnupgHome: "/home/user/.gnupg/",
+			want:      "/run/user/1000/gnupg",
+		},
+		{
+			// Verified using "GNUPGHOME=/tmp/gh gpgconf --list-dirs socketdir".
+			name:      "custom",
+			home:      "/home/user",
+			gnupgHome: "/tmp/gh",
+			want:      "/run/user/1000/gnupg/d.ffabiqijjfckceggnzrykjtw",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			homeDir, isDefault := gpgHomeDir(tc.home, tc.gnupgHome)
+
+			if got := gpgSocketDir("/run/user/1000", homeDir, isDefault); got != tc.want {
+				t.Errorf("gpgSocketDir() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestProgramSetupGPGAgent(t *testing.T) {
+	tmpdir := t.TempDir()
+	runtimeDir := filepath.Join(tmpdir, "run")
+	homeDir := filepath.Join(tmpdir, "gnupg")
+
+	t.Setenv("HOME", tmpdir)
+	t.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+	t.Setenv(gpgHomeEnv, homeDir)
+
+	p := newProgram()
+
+	r := &runtime{}
+	t.Cleanup(func() { os.RemoveAll(r.baseDir) })
+
+	mounts := newMountSet()
+	env := envMap{}
+
+	if err := p.setupGPGAgent(r, mounts, env); err != nil {
+		t.Errorf("setupGPGAgent() failed: %v", err)
+	}
+
+	if got := mounts.list(); len(got) != 0 {
+		t.Errorf("Mounts added without agent socket: %v", got)
+	}
+
+	socketDir := gpgSocketDir(runtimeDir, homeDir, false)
+
+	for _, i := range []string{socketDir, homeDir} {
+		if err := os.MkdirAll(i, 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	socket := testutil.MustWriteFile(t, filepath.Join(socketDir, gpgAgentExtraSocketName), "")
+	keyring := testutil.MustWriteFile(t, filepath.Join(homeDir, "pubring.kbx"), "")
+
+	if err := p.setupGPGAgent(r, mounts, env); err != nil {
+		t.Errorf("setupGPGAgent() failed: %v", err)
+	}
+
+	containerHomeDir := env[gpgHomeEnv]
+
+	if containerHomeDir == nil {
+		t.Fatalf("%s not set", gpgHomeEnv)
+	}
+
+	if diff := cmp.Diff([]bindMount{
+		{src: *containerHomeDir, dst: *containerHomeDir, mode: mountReadWrite},
+		{src: socket, dst: filepath.Join(*containerHomeDir, gpgAgentSocketName), mode: mountReadOnly},
+		{src: keyring, dst: filepath.Join(*containerHomeDir, "pubring.kbx"), mode: mountReadOnly},
+	}, mounts.list(), cmp.AllowUnexported(bindMount{})); diff != "" {
+		t.Errorf("Mount list diff (-want +got):\n%s", diff)
+	}
+
+	for _, i := range mounts.list() {
+		if ok, err := fileExists(i.dst); err != nil || !ok {
+			t.Errorf("Mount point %s missing: %v", i.dst, err)
+		}
+
+		if got := mounts.origin(i.dst); got != mountOriginGPGAgent {
+			t.Errorf("Mount %s has origin %q", i.dst, got)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"slices"
+	"strconv"
+)
+
+// Supplementary group of the invoking user.
+type hostGroup struct {
+	gid string
+
+	// Empty if the group has no name.
+	name string
+}
+
+// currentHostGroups returns the supplementary groups of the invoking process.
+func currentHostGroups() ([]hostGroup, error) {
+	gids, err := os.Getgroups()
+	if err != nil {
+		return nil, fmt.Errorf("getting supplementary groups: %w", err)
+	}
+
+	slices.Sort(gids)
+
+	var result []hostGroup
+
+	for _, gid := range slices.Compact(gids) {
+		g := hostGroup{gid: strconv.Itoa(gid)}
+
+		if info, err := user.LookupGroupId(g.gid); err == nil {
+			g.name = info.Name
+		}
+
+		result = append(result, g)
+	}
+
+	return result, nil
+}
+
+// matchGroupFilter reports whether a group matches any of the names or IDs.
+// An empty filter matches all groups.
+func matchGroupFilter(filter []string, g hostGroup) bool {
+	if len(filter) == 0 {
+		return true
+	}
+
+	return slices.Contains(filter, g.gid) || (g.name != "" && slices.Contains(filter, g.name))
+}
+
+// supplementaryGroups returns the forwarded host groups by ID, skipping the
+// primary group, and the explicitly added groups not forwarded already.
+func (p *program) supplementaryGroups(hostGroups []hostGroup) (forwarded, added []string) {
+	if p.forwardGroups {
+		for _, g := range hostGroups {
+			if g.gid != p.group && matchGroupFilter(p.groupFilter, g) {
+				forwarded = append(forwarded, g.gid)
+			}
+		}
+	}
+
+	for _, g := range p.groupAdd {
+		if !slices.Contains(forwarded, g) && !slices.Contains(added, g) {
+			added = append(added, g)
+		}
+	}
+
+	return forwarded, added
+}
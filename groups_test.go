@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestProgramSupplementaryGroups(t *testing.T) {
+	hostGroups := []hostGroup{
+		{gid: "100", name: "users"},
+		{gid: "969", name: "docker"},
+		{gid: "1001", name: "srv"},
+		{gid: "4242"},
+	}
+
+	for _, tc := range []struct {
+		name          string
+		forwardGroups bool
+		groupFilter   []string
+		groupAdd      []string
+		wantForwarded []string
+		wantAdded     []string
+	}{
+		{name: "defaults"},
+		{
+			name:          "forward",
+			forwardGroups: true,
+			wantForwarded: []string{"969", "1001", "4242"},
+		},
+		{
+			name:          "filter",
+			forwardGroups: true,
+			groupFilter:   []string{"docker", "4242", "unknown"},
+			wantForwarded: []string{"969", "4242"},
+		},
+		{
+			name:        "filter without forwarding",
+			groupFilter: []string{"docker"},
+		},
+		{
+			name:      "add",
+			groupAdd:  []string{"postgres", "969", "postgres"},
+			wantAdded: []string{"postgres", "969"},
+		},
+		{
+			name:          "forward and add",
+			forwardGroups: true,
+			groupFilter:   []string{"srv"},
+			groupAdd:      []string{"postgres", "1001"},
+			wantForwarded: []string{"1001"},
+			wantAdded:     []string{"postgres"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newProgram()
+			p.group = "100"
+			p.forwardGroups = tc.forwardGroups
+			p.groupFilter = tc.groupFilter
+			p.groupAdd = tc.groupAdd
+
+			forwarded, added := p.supplementaryGroups(hostGroups)
+
+			if diff := cmp.Diff(tc.wantForwarded, forwarded); diff != "" {
+				t.Errorf("supplementaryGroups() forwarded diff (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.wantAdded, added); diff != "" {
+				t.Errorf("supplementaryGroups() added diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -14,3 +14,13 @@ func MustWriteFile(t *testing.T, path string, content string) string {
 
 	return path
 }
+
+func MustWriteExecutable(t *testing.T, path string, content string) string {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0o700); err != nil {
+		t.Errorf("WriteFile(%q) failed: %v", path, err)
+	}
+
+	return path
+}
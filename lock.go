@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const lockFileName = ".cocoon.lock"
+
+const lockFileHeader = "# Generated by \"cocoon lock update\". Do not edit.\n"
+
+// Digests of image references. A lock file makes all users of a project run
+// identical images.
+type lockFile struct {
+	// Map from image reference to digest, e.g. "sha256:...".
+	Images map[string]string `yaml:"images"`
+}
+
+// readLockFile reads the lock file at the given path. A missing file results
+// in an empty lock.
+func readLockFile(path string) (*lockFile, error) {
+	lock := &lockFile{}
+
+	if err := readYAMLFile("lock", path, lock); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return &lockFile{}, nil
+		}
+
+		return nil, err
+	}
+
+	return lock, nil
+}
+
+func (l *lockFile) write(path string) error {
+	content, err := yaml.Marshal(l)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, append([]byte(lockFileHeader), content...), 0o644)
+}
+
+// lockFilePath returns the location of the project's lock file.
+func (p *program) lockFilePath() string {
+	return filepath.Join(p.projectDir, lockFileName)
+}
+
+// imageName returns an image reference without its tag and digest.
+func imageName(ref string) string {
+	name, _, _ := strings.Cut(ref, "@")
+
+	if idx := strings.LastIndex(name, ":"); idx > strings.LastIndex(name, "/") {
+		name = name[:idx]
+	}
+
+	return name
+}
+
+// pinImage returns a reference to the image with the given digest. References
+// containing a digest already are returned unmodified.
+func pinImage(ref, digest string) string {
+	if strings.Contains(ref, "@") {
+		return ref
+	}
+
+	return imageName(ref) + "@" + digest
+}
+
+// selectRepoDigest returns the digest of the repository matching the image
+// reference. The first digest is used if none matches, e.g. because the
+// runtime normalized the repository name.
+func selectRepoDigest(ref string, repoDigests []string) (string, error) {
+	if len(repoDigests) == 0 {
+		return "", fmt.Errorf("image %s has no repository digest, e.g. because it was built locally", ref)
+	}
+
+	name := imageName(ref)
+	result := repoDigests[0]
+
+	for _, i := range repoDigests {
+		if repo, _, _ := strings.Cut(i, "@"); repo == name {
+			result = i
+			break
+		}
+	}
+
+	_, digest, ok := strings.Cut(result, "@")
+	if !ok || digest == "" {
+		return "", fmt.Errorf("image %s: invalid repository digest %q", ref, result)
+	}
+
+	return digest, nil
+}
+
+// imageDigest returns the registry digest of a local image.
+func (c *containerCli) imageDigest(ctx context.Context, ref string) (string, error) {
+	output, err := c.output(ctx, "image", "inspect", "--format={{json .RepoDigests}}", ref)
+	if err != nil {
+		return "", err
+	}
+
+	var repoDigests []string
+
+	if err := json.Unmarshal([]byte(output), &repoDigests); err != nil {
+		return "", fmt.Errorf("parsing digests of image %s: %w", ref, err)
+	}
+
+	return selectRepoDigest(ref, repoDigests)
+}
+
+// updateLock pulls the images referenced by the configuration file and the
+// command line and records their digests in the lock file.
+func (p *program) updateLock(ctx context.Context) error {
+	cli, err := p.containerCli()
+	if err != nil {
+		return err
+	}
+
+	refs := slices.Clone(p.configImages)
+
+	if p.image != "" {
+		refs = append(refs, p.image)
+	}
+
+	slices.Sort(refs)
+	refs = slices.Compact(refs)
+
+	if len(refs) == 0 {
+		return fmt.Errorf("no images to lock, specify one using --image or a %s file", configFileName)
+	}
+
+	lock := &lockFile{
+		Images: map[string]string{},
+	}
+
+	for _, ref := range refs {
+		if strings.Contains(ref, "@") {
+			// Pinned already.
+			continue
+		}
+
+		if err := p.pull(ctx, cli, ref); err != nil {
+			return err
+		}
+
+		digest, err := cli.imageDigest(ctx, ref)
+		if err != nil {
+			return err
+		}
+
+		lock.Images[ref] = digest
+	}
+
+	path := p.lockFilePath()
+
+	if err := lock.write(path); err != nil {
+		return fmt.Errorf("writing lock file: %w", err)
+	}
+
+	for _, ref := range slices.Sorted(maps.Keys(lock.Images)) {
+		fmt.Fprintf(p.stdout, "%s %s\n", ref, lock.Images[ref])
+	}
+
+	return nil
+}
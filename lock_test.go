@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/hansmi/cocoon/internal/testutil"
+)
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestPinImage(t *testing.T) {
+	for _, tc := range []struct {
+		ref  string
+		want string
+	}{
+		{ref: "alpine", want: "alpine@" + testDigest},
+		{ref: "golang:1.22", want: "golang@" + testDigest},
+		{ref: "docker.io/library/golang:latest", want: "docker.io/library/golang@" + testDigest},
+		{ref: "localhost:5000/tools", want: "localhost:5000/tools@" + testDigest},
+		{ref: "localhost:5000/tools:v1", want: "localhost:5000/tools@" + testDigest},
+		{ref: "alpine@sha256:abc", want: "alpine@sha256:abc"},
+	} {
+		t.Run(tc.ref, func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, pinImage(tc.ref, testDigest)); diff != "" {
+				t.Errorf("pinImage() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSelectRepoDigest(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		ref         string
+		repoDigests []string
+		want        string
+		wantErr     error
+	}{
+		{
+			name:    "none",
+			ref:     "cocoon-project:abc",
+			wantErr: cmpopts.AnyError,
+		},
+		{
+			name:        "matching",
+			ref:         "example.com/tools:v1",
+			repoDigests: []string{"example.com/other@sha256:aaa", "example.com/tools@sha256:bbb"},
+			want:        "sha256:bbb",
+		},
+		{
+			name:        "normalized",
+			ref:         "golang:latest",
+			repoDigests: []string{"docker.io/library/golang@sha256:ccc"},
+			want:        "sha256:ccc",
+		},
+		{
+			name:        "invalid",
+			ref:         "golang",
+			repoDigests: []string{"golang"},
+			wantErr:     cmpopts.AnyError,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := selectRepoDigest(tc.ref, tc.repoDigests)
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("selectRepoDigest() error diff (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("selectRepoDigest() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), lockFileName)
+
+	if got, err := readLockFile(path); err != nil {
+		t.Errorf("readLockFile() failed for missing file: %v", err)
+	} else if len(got.Images) > 0 {
+		t.Errorf("readLockFile() returned images for missing file: %v", got.Images)
+	}
+
+	want := &lockFile{
+		Images: map[string]string{
+			"alpine":      testDigest,
+			"golang:1.22": "sha256:abc",
+		},
+	}
+
+	if err := want.write(path); err != nil {
+		t.Errorf("write() failed: %v", err)
+	}
+
+	got, err := readLockFile(path)
+	if err != nil {
+		t.Errorf("readLockFile() failed: %v", err)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Lock file diff (-want +got):\n%s", diff)
+	}
+
+	testutil.MustWriteFile(t, path, "unknown: 1\n")
+
+	if _, err := readLockFile(path); err == nil {
+		t.Errorf("readLockFile() succeeded for invalid file")
+	}
+}
+
+func TestProgramUpdateLock(t *testing.T) {
+	tmpdir := t.TempDir()
+	log := filepath.Join(tmpdir, "log")
+
+	// Fake CLI reporting the length of the reference as the digest.
+	docker := testutil.MustWriteExecutable(t, filepath.Join(tmpdir, "docker"), `#!/bin/sh
+echo "$*" >> '`+log+`'
+case "$1" in
+image)
+	echo '["'"${4%:*}"'@sha256:'"${#4}"'"]'
+	;;
+esac
+`)
+
+	var stdout strings.Builder
+
+	p := newProgram()
+	p.stdout = &stdout
+	p.stderr = &strings.Builder{}
+	p.containerEngine = string(engineDocker)
+	p.dockerCliProgram = docker
+	p.projectDir = tmpdir
+	p.configImages = []string{"golang:1.22", "alpine:3", "pinned@sha256:abc"}
+	p.image = "golang:1.22"
+
+	if err := p.updateLock(context.Background()); err != nil {
+		t.Errorf("updateLock() failed: %v", err)
+	}
+
+	lock, err := readLockFile(filepath.Join(tmpdir, lockFileName))
+	if err != nil {
+		t.Errorf("readLockFile() failed: %v", err)
+	}
+
+	if diff := cmp.Diff(map[string]string{
+		"alpine:3":    "sha256:8",
+		"golang:1.22": "sha256:11",
+	}, lock.Images); diff != "" {
+		t.Errorf("Locked images diff (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff("alpine:3 sha256:8\ngolang:1.22 sha256:11\n", stdout.String()); diff != "" {
+		t.Errorf("Output diff (-want +got):\n%s", diff)
+	}
+
+	content, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]string{
+		"pull alpine:3",
+		"image inspect --format={{json .RepoDigests}} alpine:3",
+		"pull golang:1.22",
+		"image inspect --format={{json .RepoDigests}} golang:1.22",
+	}, strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")); diff != "" {
+		t.Errorf("CLI invocation diff (-want +got):\n%s", diff)
+	}
+}
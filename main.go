@@ -18,9 +18,8 @@ func main() {
 
 	if err == nil {
 		p.registerFlags(kingpin.CommandLine)
-		kingpin.Parse()
 
-		err = p.run(context.Background())
+		err = p.execute(context.Background(), kingpin.Parse())
 
 		var cmdErr *commandError
 
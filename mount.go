@@ -2,7 +2,9 @@ package main
 
 import (
 	"cmp"
+	"errors"
 	"fmt"
+	"log"
 	"maps"
 	"path/filepath"
 	"slices"
@@ -19,8 +21,35 @@ const (
 	mountReadWrite                  // rw
 )
 
+var errMountSpecInvalid = errors.New("invalid mount specification")
+
+// Reason for a mount being present.
+type mountOrigin string
+
+const (
+	mountOriginDefault  mountOrigin = "default"
+	mountOriginFlag     mountOrigin = "flag"
+	mountOriginEnvVar   mountOrigin = "env var"
+	mountOriginConfig   mountOrigin = "config"
+	mountOriginSSHAgent mountOrigin = "ssh-agent"
+	mountOriginGPGAgent mountOrigin = "gpg-agent"
+	mountOriginX11      mountOrigin = "x11"
+	mountOriginWayland  mountOrigin = "wayland"
+	mountOriginAudio    mountOrigin = "audio"
+	mountOriginDBus     mountOrigin = "dbus"
+	mountOriginPasswd   mountOrigin = "passwd"
+)
+
+type bindMount struct {
+	src  string
+	dst  string
+	mode mountMode
+}
+
+// Set of bind mounts keyed by their destination within the container.
 type mountSet struct {
-	entries map[string]mountMode
+	entries map[string]bindMount
+	origins map[string]mountOrigin
 }
 
 var _ fmt.Stringer = (*mountSet)(nil)
@@ -40,7 +69,8 @@ func comparePaths(a, b string) int {
 
 func newMountSet() *mountSet {
 	return &mountSet{
-		entries: map[string]mountMode{},
+		entries: map[string]bindMount{},
+		origins: map[string]mountOrigin{},
 	}
 }
 
@@ -51,36 +81,135 @@ func (s *mountSet) String() string {
 func (s *mountSet) clone() *mountSet {
 	result := newMountSet()
 	maps.Copy(result.entries, s.entries)
+	maps.Copy(result.origins, s.origins)
 	return result
 }
 
-func (s *mountSet) set(path string, mode mountMode) {
-	path = filepath.Clean(path)
+// set mounts a path at the same location within the container.
+func (s *mountSet) set(path string, mode mountMode, origin mountOrigin) {
+	s.bind(path, path, mode, origin)
+}
+
+// bind mounts a source path at a destination within the container. When the
+// destination is already in use by the same source the read-write mode takes
+// precedence. A different source replaces the existing mount. The origin is
+// recorded for the mount taking effect.
+func (s *mountSet) bind(src, dst string, mode mountMode, origin mountOrigin) {
+	src = filepath.Clean(src)
+	dst = filepath.Clean(dst)
 
-	if existing, ok := s.entries[path]; !ok || existing == mountReadOnly {
-		s.entries[path] = mode
+	if existing, ok := s.entries[dst]; ok && existing.src == src && existing.mode == mountReadWrite {
+		return
+	}
+
+	s.entries[dst] = bindMount{
+		src:  src,
+		dst:  dst,
+		mode: mode,
 	}
+	s.origins[dst] = origin
 }
 
-func (s *mountSet) toDockerFlags() []string {
-	var result []string
+// add binds a mount given by the user. Mounting a different source at a
+// destination given by the user already is an error. Replacing a default
+// mount, e.g. of the working directory, is logged.
+func (s *mountSet) add(m bindMount, origin mountOrigin) error {
+	dst := filepath.Clean(m.dst)
 
-	for _, path := range slices.SortedFunc(maps.Keys(s.entries), comparePaths) {
-		value := fmt.Sprintf("--mount=type=bind,src=%[1]s,dst=%[1]s", path)
+	if existing, ok := s.entries[dst]; ok && existing.src != filepath.Clean(m.src) {
+		switch s.origins[dst] {
+		case mountOriginFlag, mountOriginEnvVar, mountOriginConfig:
+			return fmt.Errorf("%w: %s is mounted from both %s and %s", errMountSpecInvalid, dst, existing.src, m.src)
 
-		if s.entries[path] != mountReadWrite {
-			value += ",readonly"
+		case mountOriginDefault:
+			log.Printf("Warning: mount of %s replaces default mount of %s at %s", m.src, existing.src, dst)
 		}
+	}
+
+	s.bind(m.src, dst, m.mode, origin)
+
+	return nil
+}
+
+// origin returns the origin of the mount at the given destination.
+func (s *mountSet) origin(dst string) mountOrigin {
+	return s.origins[filepath.Clean(dst)]
+}
+
+// remove deletes the mount at the given destination, if any.
+func (s *mountSet) remove(dst string) {
+	dst = filepath.Clean(dst)
+
+	delete(s.entries, dst)
+	delete(s.origins, dst)
+}
+
+func (s *mountSet) has(dst string) bool {
+	_, ok := s.entries[filepath.Clean(dst)]
+	return ok
+}
 
-		result = append(result, value)
+// list returns all mounts with parent directories ordered before their
+// children.
+func (s *mountSet) list() []bindMount {
+	var result []bindMount
+
+	for _, dst := range slices.SortedFunc(maps.Keys(s.entries), comparePaths) {
+		result = append(result, s.entries[dst])
 	}
 
 	return result
 }
 
+// parseMountSpec parses a mount specification in the form
+// "SRC[:DST[:ro|rw]]". The destination defaults to the source path and the
+// mode to the given default. An explicit destination must be absolute.
+func parseMountSpec(value string, mode mountMode) (bindMount, error) {
+	parts := strings.Split(value, ":")
+
+	if len(parts) > 3 {
+		return bindMount{}, fmt.Errorf("%w: expected SRC[:DST[:ro|rw]]: %q", errMountSpecInvalid, value)
+	}
+
+	result := bindMount{
+		src:  parts[0],
+		dst:  parts[0],
+		mode: mode,
+	}
+
+	if len(parts) > 1 {
+		result.dst = parts[1]
+
+		if !filepath.IsAbs(result.dst) {
+			return bindMount{}, fmt.Errorf("%w: destination must be an absolute path: %q", errMountSpecInvalid, value)
+		}
+	}
+
+	if result.src == "" || result.dst == "" {
+		return bindMount{}, fmt.Errorf("%w: %q", errMountSpecInvalid, value)
+	}
+
+	if len(parts) > 2 {
+		switch parts[2] {
+		case mountReadOnly.String():
+			result.mode = mountReadOnly
+		case mountReadWrite.String():
+			result.mode = mountReadWrite
+		default:
+			return bindMount{}, fmt.Errorf("%w: unknown mode %q", errMountSpecInvalid, parts[2])
+		}
+	}
+
+	return result, nil
+}
+
 type mountSetFlag struct {
 	s    *mountSet
 	mode mountMode
+
+	// Kingpin applies values from the command line and from the environment
+	// exclusively of each other.
+	setByUser bool
 }
 
 var _ kingpin.Value = (*mountSetFlag)(nil)
@@ -94,16 +223,26 @@ func (*mountSetFlag) IsCumulative() bool {
 }
 
 func (f *mountSetFlag) Set(value string) error {
-	for _, i := range filepath.SplitList(value) {
-		f.s.set(i, f.mode)
+	origin := mountOriginEnvVar
+
+	if f.setByUser {
+		origin = mountOriginFlag
 	}
 
-	return nil
+	m, err := parseMountSpec(value, f.mode)
+	if err != nil {
+		return err
+	}
+
+	return f.s.add(m, origin)
 }
 
-func mountSetVar(s kingpin.Settings, target *mountSet, mode mountMode) {
-	s.SetValue(&mountSetFlag{
+func mountSetVar(fc *kingpin.FlagClause, target *mountSet, mode mountMode) {
+	f := &mountSetFlag{
 		s:    target,
 		mode: mode,
-	})
+	}
+
+	fc.IsSetByUser(&f.setByUser)
+	fc.SetValue(f)
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"slices"
 	"testing"
 
@@ -67,9 +68,10 @@ func TestComparePaths(t *testing.T) {
 
 func TestMountSet(t *testing.T) {
 	for _, tc := range []struct {
-		name string
-		args []string
-		want []string
+		name    string
+		args    []string
+		want    []bindMount
+		wantErr error
 	}{
 		{name: "empty"},
 		{
@@ -78,9 +80,9 @@ func TestMountSet(t *testing.T) {
 				"--ro=/",
 				"--rw=/home/foo",
 			},
-			want: []string{
-				"--mount=type=bind,src=/,dst=/,readonly",
-				"--mount=type=bind,src=/home/foo,dst=/home/foo",
+			want: []bindMount{
+				{src: "/", dst: "/", mode: mountReadOnly},
+				{src: "/home/foo", dst: "/home/foo", mode: mountReadWrite},
 			},
 		},
 		{
@@ -91,12 +93,60 @@ func TestMountSet(t *testing.T) {
 				"--rw=/etc",
 				"--rw=/",
 			},
-			want: []string{
-				"--mount=type=bind,src=/,dst=/",
-				"--mount=type=bind,src=/etc,dst=/etc",
-				"--mount=type=bind,src=/home/foo,dst=/home/foo,readonly",
+			want: []bindMount{
+				{src: "/", dst: "/", mode: mountReadWrite},
+				{src: "/etc", dst: "/etc", mode: mountReadWrite},
+				{src: "/home/foo", dst: "/home/foo", mode: mountReadOnly},
+			},
+		},
+		{
+			name: "destination",
+			args: []string{
+				"--ro=/opt/toolchain-1.2:/opt/toolchain",
+				"--rw=/home/foo/.cache/go-build-ci:/root/.cache/go-build",
+				"--ro=/srv/a=b:/srv/ab",
+			},
+			want: []bindMount{
+				{src: "/opt/toolchain-1.2", dst: "/opt/toolchain", mode: mountReadOnly},
+				{src: "/srv/a=b", dst: "/srv/ab", mode: mountReadOnly},
+				{src: "/home/foo/.cache/go-build-ci", dst: "/root/.cache/go-build", mode: mountReadWrite},
 			},
 		},
+		{
+			name: "mode",
+			args: []string{
+				"--ro=/cache:/cache:rw",
+				"--rw=/etc:/etc:ro",
+			},
+			want: []bindMount{
+				{src: "/cache", dst: "/cache", mode: mountReadWrite},
+				{src: "/etc", dst: "/etc", mode: mountReadOnly},
+			},
+		},
+		{
+			name: "same source",
+			args: []string{
+				"--rw=/src",
+				"--ro=/src:/src",
+				"--ro=/srv/a:/data",
+				"--ro=/srv/a:/data/",
+			},
+			want: []bindMount{
+				{src: "/srv/a", dst: "/data", mode: mountReadOnly},
+				{src: "/src", dst: "/src", mode: mountReadWrite},
+			},
+		},
+		{
+			name: "destination conflict",
+			args: []string{
+				"--rw=/srv/a:/data",
+				"--ro=/srv/b:/data/",
+			},
+			want: []bindMount{
+				{src: "/srv/a", dst: "/data", mode: mountReadWrite},
+			},
+			wantErr: errMountSpecInvalid,
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			s := newMountSet()
@@ -106,12 +156,14 @@ func TestMountSet(t *testing.T) {
 			mountSetVar(app.Flag("ro", ""), s, mountReadOnly)
 			mountSetVar(app.Flag("rw", ""), s, mountReadWrite)
 
-			if _, err := app.Parse(tc.args); err != nil {
-				t.Errorf("Parsing flags failed: %v", err)
+			_, err := app.Parse(tc.args)
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("Parsing flags error diff (-want +got):\n%s", diff)
 			}
 
-			if diff := cmp.Diff(tc.want, s.toDockerFlags(), cmpopts.EquateEmpty()); diff != "" {
-				t.Errorf("Docker flags diff (-want +got):\n%s", diff)
+			if diff := cmp.Diff(tc.want, s.list(), cmp.AllowUnexported(bindMount{}), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("Mount list diff (-want +got):\n%s", diff)
 			}
 		})
 	}
@@ -122,25 +174,115 @@ func TestMountSetClone(t *testing.T) {
 
 	first := orig.clone()
 
-	if got := first.toDockerFlags(); len(got) != 0 {
+	if got := first.list(); len(got) != 0 {
 		t.Errorf("Clone of empty set %#v is not empty: %#v", orig, got)
 	}
 
-	orig.set("/", mountReadOnly)
-	orig.set("/tmp", mountReadWrite)
+	orig.set("/", mountReadOnly, mountOriginDefault)
+	orig.set("/tmp", mountReadWrite, mountOriginDefault)
 
-	if got := first.toDockerFlags(); len(got) != 0 {
+	if got := first.list(); len(got) != 0 {
 		t.Errorf("Clone was modified when it shouldn't: %#v", got)
 	}
 
-	want := []string{
-		"--mount=type=bind,src=/,dst=/,readonly",
-		"--mount=type=bind,src=/tmp,dst=/tmp",
+	want := []bindMount{
+		{src: "/", dst: "/", mode: mountReadOnly},
+		{src: "/tmp", dst: "/tmp", mode: mountReadWrite},
 	}
 
 	for _, s := range []*mountSet{orig, orig.clone()} {
-		if diff := cmp.Diff(want, s.toDockerFlags()); diff != "" {
-			t.Errorf("Docker flags diff (-want +got):\n%s", diff)
+		if diff := cmp.Diff(want, s.list(), cmp.AllowUnexported(bindMount{})); diff != "" {
+			t.Errorf("Mount list diff (-want +got):\n%s", diff)
 		}
 	}
 }
+
+func TestMountSetOrigin(t *testing.T) {
+	t.Setenv("COCOON_TEST_MOUNT", "/srv/env\n/srv/both")
+
+	s := newMountSet()
+	s.set("/etc/hosts", mountReadOnly, mountOriginDefault)
+	s.set("/srv/both", mountReadOnly, mountOriginDefault)
+
+	app := kingpin.New(t.Name(), "")
+
+	mountSetVar(app.Flag("ro", "").Envar("COCOON_TEST_MOUNT"), s, mountReadOnly)
+	mountSetVar(app.Flag("rw", "").Envar("COCOON_TEST_MOUNT_RW"), s, mountReadWrite)
+
+	if _, err := app.Parse([]string{"--rw=/srv/flag", "--rw=/etc/hosts:/etc/hosts"}); err != nil {
+		t.Errorf("Parsing flags failed: %v", err)
+	}
+
+	for dst, want := range map[string]mountOrigin{
+		"/etc/hosts": mountOriginFlag,
+		"/srv/both":  mountOriginEnvVar,
+		"/srv/env":   mountOriginEnvVar,
+		"/srv/flag":  mountOriginFlag,
+		"/missing":   "",
+	} {
+		if got := s.origin(dst); got != want {
+			t.Errorf("origin(%q) = %q, want %q", dst, got, want)
+		}
+	}
+
+	if got, want := s.clone().origin("/srv/env"), mountOriginEnvVar; got != want {
+		t.Errorf("Clone returned origin %q, want %q", got, want)
+	}
+}
+
+func TestParseMountSpec(t *testing.T) {
+	for _, tc := range []struct {
+		value   string
+		want    bindMount
+		wantErr error
+	}{
+		{value: "/srv", want: bindMount{src: "/srv", dst: "/srv", mode: mountReadOnly}},
+		{value: "relative", want: bindMount{src: "relative", dst: "relative", mode: mountReadOnly}},
+		{value: "/a:/b", want: bindMount{src: "/a", dst: "/b", mode: mountReadOnly}},
+		{value: "/a:/b:rw", want: bindMount{src: "/a", dst: "/b", mode: mountReadWrite}},
+		{value: "/a:/b:ro", want: bindMount{src: "/a", dst: "/b", mode: mountReadOnly}},
+		{value: "/a=b:/b", want: bindMount{src: "/a=b", dst: "/b", mode: mountReadOnly}},
+		{value: "cache:/root/.cache", want: bindMount{src: "cache", dst: "/root/.cache", mode: mountReadOnly}},
+		{value: "", wantErr: errMountSpecInvalid},
+		{value: ":/b", wantErr: errMountSpecInvalid},
+		{value: "/a:", wantErr: errMountSpecInvalid},
+		{value: "/a:relative", wantErr: errMountSpecInvalid},
+		{value: "/a:/b:xyz", wantErr: errMountSpecInvalid},
+		{value: "/a:/b:ro:extra", wantErr: errMountSpecInvalid},
+	} {
+		t.Run(tc.value, func(t *testing.T) {
+			got, err := parseMountSpec(tc.value, mountReadOnly)
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("parseMountSpec() error diff (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.want, got, cmp.AllowUnexported(bindMount{})); diff != "" {
+				t.Errorf("parseMountSpec() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMountSetAdd(t *testing.T) {
+	s := newMountSet()
+	s.set("/work", mountReadWrite, mountOriginDefault)
+
+	if err := s.add(bindMount{src: "/srv/typo", dst: "/work", mode: mountReadOnly}, mountOriginFlag); err != nil {
+		t.Errorf("add() replacing default mount failed: %v", err)
+	}
+
+	if err := s.add(bindMount{src: "/srv/other", dst: "/work", mode: mountReadOnly}, mountOriginConfig); !errors.Is(err, errMountSpecInvalid) {
+		t.Errorf("add() with conflicting source returned %v, want %v", err, errMountSpecInvalid)
+	}
+
+	if err := s.add(bindMount{src: "/srv/typo", dst: "/work/", mode: mountReadWrite}, mountOriginConfig); err != nil {
+		t.Errorf("add() with same source failed: %v", err)
+	}
+
+	if diff := cmp.Diff([]bindMount{
+		{src: "/srv/typo", dst: "/work", mode: mountReadWrite},
+	}, s.list(), cmp.AllowUnexported(bindMount{})); diff != "" {
+		t.Errorf("Mount list diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/user"
+	"slices"
+	"strings"
+)
+
+const (
+	passwdPath = "/etc/passwd"
+	groupPath  = "/etc/group"
+)
+
+// User and groups for which entries are synthesized. Empty for users only
+// defined within the image.
+type passwdIdentity struct {
+	user   *user.User
+	groups []*user.Group
+}
+
+// localPasswdIdentity returns the local user with the given name or ID and
+// their groups. Groups without a name are skipped.
+func localPasswdIdentity(value string) (*passwdIdentity, error) {
+	u, err := lookupLocalUser(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if u == nil {
+		return &passwdIdentity{}, nil
+	}
+
+	result := &passwdIdentity{user: u}
+
+	gids, err := u.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("looking up groups of %s: %w", u.Username, err)
+	}
+
+	if !slices.Contains(gids, u.Gid) {
+		gids = append([]string{u.Gid}, gids...)
+	}
+
+	for _, gid := range gids {
+		if g, err := user.LookupGroupId(gid); err == nil {
+			result.groups = append(result.groups, g)
+		}
+	}
+
+	return result, nil
+}
+
+// passwdField removes characters which would break the file format.
+func passwdField(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ':' || r == '\n' || r == '\r' {
+			return -1
+		}
+
+		return r
+	}, value)
+}
+
+// passwdEntry returns the passwd line for the user.
+func (id *passwdIdentity) passwdEntry(shell string) string {
+	u := id.user
+
+	return strings.Join([]string{
+		passwdField(u.Username), "x", u.Uid, u.Gid,
+		passwdField(u.Name), passwdField(u.HomeDir), passwdField(shell),
+	}, ":")
+}
+
+// groupEntries returns a group line for each of the user's groups with the
+// user as the only member.
+func (id *passwdIdentity) groupEntries() []string {
+	var result []string
+
+	for _, g := range id.groups {
+		result = append(result, strings.Join([]string{
+			passwdField(g.Name), "x", g.Gid, passwdField(id.user.Username),
+		}, ":"))
+	}
+
+	return result
+}
+
+// mergeColonFile combines the lines of a passwd or group file with additional
+// entries. Existing entries with the same name or numeric ID as an additional
+// entry are removed. Comments and malformed lines are kept.
+func mergeColonFile(content []byte, entries []string) []byte {
+	names := map[string]bool{}
+	ids := map[string]bool{}
+
+	for _, i := range entries {
+		fields := strings.Split(i, ":")
+		names[fields[0]] = true
+		ids[fields[2]] = true
+	}
+
+	var buf bytes.Buffer
+
+	for line := range strings.Lines(string(content)) {
+		line = strings.TrimRight(line, "\n")
+
+		if fields := strings.Split(line, ":"); len(fields) >= 3 && (names[fields[0]] || ids[fields[2]]) {
+			continue
+		}
+
+		if line != "" {
+			buf.WriteString(line + "\n")
+		}
+	}
+
+	for _, i := range entries {
+		buf.WriteString(i + "\n")
+	}
+
+	return buf.Bytes()
+}
+
+// writeRuntimeFile stores content in a file readable by all users within the
+// container.
+func writeRuntimeFile(r *runtime, pattern string, content []byte) (string, error) {
+	f, err := r.createFile(pattern)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return "", err
+	}
+
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return "", err
+	}
+
+	return f.Name(), f.Close()
+}
+
+// setupPasswd replaces the default mounts of the host's passwd and group
+// files with files combining the entries of the image with those of the
+// invoking user. Paths mounted explicitly are left unmodified.
+func (p *program) setupPasswd(ctx context.Context, r *runtime, backend containerBackend, mounts *mountSet, id *passwdIdentity) error {
+	var paths []string
+
+	for _, path := range []string{passwdPath, groupPath} {
+		if origin := mounts.origin(path); origin == "" || origin == mountOriginDefault {
+			paths = append(paths, path)
+		}
+	}
+
+	if len(paths) == 0 {
+		return nil
+	}
+
+	imageFiles := map[string][]byte{}
+
+	if !p.simulated() {
+		var err error
+
+		if imageFiles, err = backend.readImageFiles(ctx, p.image, paths); err != nil {
+			return fmt.Errorf("reading image files: %w", err)
+		}
+	}
+
+	for _, path := range paths {
+		var entries []string
+
+		// Users only defined within the image keep the image's entries.
+		switch {
+		case id.user == nil:
+		case path == passwdPath:
+			entries = []string{id.passwdEntry(p.shell)}
+		default:
+			entries = id.groupEntries()
+		}
+
+		file, err := writeRuntimeFile(r, "etc-"+strings.TrimPrefix(path, "/etc/"), mergeColonFile(imageFiles[path], entries))
+		if err != nil {
+			return err
+		}
+
+		mounts.bind(file, path, mountReadOnly, mountOriginPasswd)
+	}
+
+	return nil
+}
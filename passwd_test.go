@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hansmi/cocoon/internal/testutil"
+)
+
+func TestMergeColonFile(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		entries []string
+		want    string
+	}{
+		{
+			name:    "empty",
+			entries: []string{"user:x:1000:1000::/home/user:/bin/sh"},
+			want:    "user:x:1000:1000::/home/user:/bin/sh\n",
+		},
+		{
+			name: "passwd",
+			content: "root:x:0:0:root:/root:/bin/sh\n" +
+				"# comment\n" +
+				"\n" +
+				"ubuntu:x:1000:1000:Ubuntu:/home/ubuntu:/bin/bash\n" +
+				"user:x:999:999::/nonexistent:/usr/sbin/nologin\n" +
+				"nobody:x:65534:65534:nobody:/nonexistent:/usr/sbin/nologin",
+			entries: []string{"user:x:1000:100:User:/home/user:/bin/sh"},
+			want: "root:x:0:0:root:/root:/bin/sh\n" +
+				"# comment\n" +
+				"nobody:x:65534:65534:nobody:/nonexistent:/usr/sbin/nologin\n" +
+				"user:x:1000:100:User:/home/user:/bin/sh\n",
+		},
+		{
+			name: "group",
+			content: "root:x:0:\n" +
+				"users:x:100:\n" +
+				"docker:x:998:ubuntu\n",
+			entries: []string{
+				"users:x:100:user",
+				"docker:x:969:user",
+			},
+			want: "root:x:0:\n" +
+				"users:x:100:user\n" +
+				"docker:x:969:user\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := mergeColonFile([]byte(tc.content), tc.entries)
+
+			if diff := cmp.Diff(tc.want, string(got)); diff != "" {
+				t.Errorf("mergeColonFile() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPasswdIdentityEntries(t *testing.T) {
+	id := &passwdIdentity{
+		user: &user.User{
+			Uid:      "1000",
+			Gid:      "100",
+			Username: "user",
+			Name:     "Jane: Doe",
+			HomeDir:  "/home/user",
+		},
+		groups: []*user.Group{
+			{Gid: "100", Name: "users"},
+			{Gid: "969", Name: "docker"},
+		},
+	}
+
+	if diff := cmp.Diff("user:x:1000:100:Jane Doe:/home/user:/bin/bash", id.passwdEntry("/bin/bash")); diff != "" {
+		t.Errorf("passwdEntry() diff (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff([]string{
+		"users:x:100:user",
+		"docker:x:969:user",
+	}, id.groupEntries()); diff != "" {
+		t.Errorf("groupEntries() diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestProgramSetupPasswd(t *testing.T) {
+	rootfs := t.TempDir()
+
+	if err := os.Mkdir(filepath.Join(rootfs, "etc"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.MustWriteFile(t, filepath.Join(rootfs, "etc", "passwd"), "root:x:0:0:root:/root:/bin/sh\n")
+
+	id := &passwdIdentity{
+		user: &user.User{
+			Uid:      "1000",
+			Gid:      "100",
+			Username: "user",
+			HomeDir:  "/home/user",
+		},
+		groups: []*user.Group{
+			{Gid: "100", Name: "users"},
+		},
+	}
+
+	for _, tc := range []struct {
+		name       string
+		mount      bool
+		wantPasswd string
+		wantGroup  string
+	}{
+		{
+			name:       "defaults",
+			wantPasswd: "root:x:0:0:root:/root:/bin/sh\nuser:x:1000:100::/home/user:/bin/sh\n",
+			wantGroup:  "users:x:100:user\n",
+		},
+		{
+			name:      "explicit passwd mount",
+			mount:     true,
+			wantGroup: "users:x:100:user\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newProgram()
+			p.shell = "/bin/sh"
+
+			r := &runtime{}
+			t.Cleanup(func() { os.RemoveAll(r.baseDir) })
+
+			mounts := newMountSet()
+			mounts.set(groupPath, mountReadOnly, mountOriginDefault)
+
+			if tc.mount {
+				mounts.set(passwdPath, mountReadOnly, mountOriginFlag)
+			}
+
+			if err := p.setupPasswd(context.Background(), r, newBwrapBackend("bwrap", rootfs), mounts, id); err != nil {
+				t.Fatalf("setupPasswd() failed: %v", err)
+			}
+
+			for _, i := range []struct {
+				path string
+				want string
+			}{
+				{passwdPath, tc.wantPasswd},
+				{groupPath, tc.wantGroup},
+			} {
+				if i.want == "" {
+					if origin := mounts.origin(i.path); origin == mountOriginPasswd {
+						t.Errorf("Explicit mount of %s was replaced", i.path)
+					}
+
+					continue
+				}
+
+				if origin := mounts.origin(i.path); origin != mountOriginPasswd {
+					t.Fatalf("Mount of %s has origin %q", i.path, origin)
+				}
+
+				m := mounts.entries[i.path]
+
+				if m.mode != mountReadOnly {
+					t.Errorf("Mount of %s is writable", i.path)
+				}
+
+				content, err := os.ReadFile(m.src)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if diff := cmp.Diff(i.want, string(content)); diff != "" {
+					t.Errorf("%s diff (-want +got):\n%s", i.path, diff)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Label attached to persistent containers. The value is a hash of the
+// container configuration.
+const containerConfigLabel = labelPrefix + "config"
+
+// persistentContainerName returns a container name unique to a project
+// directory and image. The directory name is included for readability.
+func persistentContainerName(uid int, projectDir, image string) string {
+	sum := sha256.Sum256([]byte(projectDir + "\x00" + image))
+
+	return fmt.Sprintf("cocoon-%d-%s-%s", uid, sanitizeObjectName(filepath.Base(projectDir)), hex.EncodeToString(sum[:4]))
+}
+
+// persistentCreateCommand returns the command line for starting a persistent
+// container in the background together with a hash of its configuration. The
+// container only runs a sleeping process. Commands are started using "exec"
+// with the environment, user and working directory of each invocation.
+func (b *dockerBackend) persistentCreateCommand(r *runtime, spec *runSpec) ([]string, string, error) {
+	createSpec := *spec
+	createSpec.workdir = "/"
+	createSpec.env = nil
+	createSpec.tty = false
+	createSpec.entrypoint = "sleep"
+	createSpec.args = []string{"infinity"}
+
+	// Labels describing the invocation don't affect the configuration.
+	createSpec.labels = nil
+
+	args, err := b.command(r, &createSpec)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// The program location doesn't affect the container.
+	sum := sha256.Sum256([]byte(strings.Join(args[1:], "\x00")))
+	hash := hex.EncodeToString(sum[:])
+
+	extraFlags := []string{"--detach", "--label=" + containerConfigLabel + "=" + hash}
+	extraFlags = append(extraFlags, dockerLabelFlags(spec.labels)...)
+
+	args = slices.Insert(args, 2, extraFlags...)
+
+	return args, hash, nil
+}
+
+// findPersistentContainer returns the ID of the running container with the
+// given name and configuration hash, if any.
+func (b *dockerBackend) findPersistentContainer(ctx context.Context, name, hash string) (string, error) {
+	output, err := b.output(ctx, "ps", "--quiet",
+		"--filter=name=^"+name+"$",
+		"--filter=label="+containerConfigLabel+"="+hash,
+		"--filter=status=running")
+	if err != nil {
+		return "", fmt.Errorf("listing containers: %w", err)
+	}
+
+	return strings.TrimSpace(output), nil
+}
+
+// ensurePersistentContainer starts the container described by the
+// specification unless it's already running with the same configuration. A
+// container whose configuration differs, e.g. due to changed mounts or
+// image, is replaced.
+func (b *dockerBackend) ensurePersistentContainer(ctx context.Context, r *runtime, spec *runSpec) error {
+	args, hash, err := b.persistentCreateCommand(r, spec)
+	if err != nil {
+		return err
+	}
+
+	if id, err := b.findPersistentContainer(ctx, spec.name, hash); err != nil {
+		return err
+	} else if id != "" {
+		return nil
+	}
+
+	existing, err := b.output(ctx, "ps", "--all", "--quiet", "--filter=name=^"+spec.name+"$")
+	if err != nil {
+		return fmt.Errorf("listing containers: %w", err)
+	}
+
+	if strings.TrimSpace(existing) != "" {
+		log.Printf("Configuration of container %s changed, recreating it", spec.name)
+
+		if _, err := b.output(ctx, "rm", "--force", spec.name); err != nil {
+			return fmt.Errorf("removing container: %w", err)
+		}
+	}
+
+	if _, err := b.output(ctx, args[1:]...); err != nil {
+		// Another invocation may have started the container concurrently.
+		if id, findErr := b.findPersistentContainer(ctx, spec.name, hash); findErr == nil && id != "" {
+			return nil
+		}
+
+		return fmt.Errorf("starting container: %w", err)
+	}
+
+	return nil
+}
+
+// persistentBackend returns the backend used for persistent containers.
+func persistentBackend(backend containerBackend) (*dockerBackend, error) {
+	b, ok := backend.(*dockerBackend)
+	if !ok {
+		return nil, fmt.Errorf("persistent containers are not supported by the %q runtime", engineBwrap)
+	}
+
+	return b, nil
+}
+
+// checkPersistentMounts rejects mounts of files created for a single
+// invocation, e.g. forwarded sockets. They're removed when cocoon exits while
+// a persistent container outlives it.
+func checkPersistentMounts(r *runtime, mounts *mountSet) error {
+	if r.baseDir == "" {
+		return nil
+	}
+
+	for _, m := range mounts.list() {
+		if m.src == r.baseDir || strings.HasPrefix(m.src, r.baseDir+string(filepath.Separator)) {
+			return fmt.Errorf("persistent containers can't mount %s (%s) as it only exists for a single invocation", m.dst, mounts.origin(m.dst))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/hansmi/cocoon/internal/ref"
+)
+
+func TestPersistentContainerName(t *testing.T) {
+	name := persistentContainerName(1000, "/home/user/my project", "alpine")
+
+	if want := "cocoon-1000-my_project-"; !strings.HasPrefix(name, want) {
+		t.Errorf("Name %q doesn't start with %q", name, want)
+	}
+
+	if other := persistentContainerName(1000, "/home/user/my project", "alpine"); name != other {
+		t.Errorf("Name isn't stable: %q != %q", name, other)
+	}
+
+	for _, other := range []string{
+		persistentContainerName(1000, "/home/user/my project", "debian"),
+		persistentContainerName(1000, "/srv/my project", "alpine"),
+	} {
+		if name == other {
+			t.Errorf("Different projects or images share name %q", name)
+		}
+	}
+}
+
+func TestDockerBackendEnsurePersistentContainer(t *testing.T) {
+	spec := runSpec{
+		name:       "test",
+		image:      "alpine",
+		user:       "1000",
+		group:      "100",
+		workdir:    "/src",
+		entrypoint: "make",
+		mounts: []bindMount{
+			{src: "/src", dst: "/src", mode: mountReadWrite},
+		},
+	}
+
+	var r runtime
+
+	t.Cleanup(func() { r.cleanup() })
+
+	createArgs, hash, err := newDockerBackend("docker").persistentCreateCommand(&r, &spec)
+	if err != nil {
+		t.Fatalf("persistentCreateCommand() failed: %v", err)
+	}
+
+	findArgs := []string{"ps", "--quiet", "--filter=name=^test$", "--filter=label=" + containerConfigLabel + "=" + hash, "--filter=status=running"}
+	listArgs := []string{"ps", "--all", "--quiet", "--filter=name=^test$"}
+
+	for _, tc := range []struct {
+		name     string
+		running  string
+		existing string
+		want     [][]string
+	}{
+		{
+			name: "missing",
+			want: [][]string{findArgs, listArgs, createArgs[1:]},
+		},
+		{
+			name:     "running",
+			running:  "abc123\n",
+			existing: "abc123\n",
+			want:     [][]string{findArgs},
+		},
+		{
+			name:     "changed",
+			existing: "abc123\n",
+			want: [][]string{
+				findArgs,
+				listArgs,
+				{"rm", "--force", "test"},
+				createArgs[1:],
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got [][]string
+
+			b := newDockerBackend("docker")
+			b.output = func(_ context.Context, args ...string) (string, error) {
+				got = append(got, args)
+
+				switch {
+				case slices.Equal(args, findArgs):
+					return tc.running, nil
+				case slices.Equal(args, listArgs):
+					return tc.existing, nil
+				}
+
+				return "", nil
+			}
+
+			if err := b.ensurePersistentContainer(context.Background(), &r, &spec); err != nil {
+				t.Errorf("ensurePersistentContainer() failed: %v", err)
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("CLI invocation diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDockerBackendPersistentCreateCommand(t *testing.T) {
+	spec := runSpec{
+		name:       "test",
+		image:      "alpine",
+		user:       "1000",
+		group:      "100",
+		workdir:    "/src",
+		entrypoint: "make",
+		args:       []string{"all"},
+		env:        envMap{"FOO": ref.Ref("bar")},
+		tty:        true,
+		labels:     map[string]string{startTimeLabel: "2024-03-01T12:30:00Z"},
+	}
+
+	var r runtime
+
+	t.Cleanup(func() { r.cleanup() })
+
+	b := newDockerBackend("/usr/bin/docker")
+
+	got, hash, err := b.persistentCreateCommand(&r, &spec)
+	if err != nil {
+		t.Fatalf("persistentCreateCommand() failed: %v", err)
+	}
+
+	if diff := cmp.Diff([]string{
+		"/usr/bin/docker", "run",
+		"--detach",
+		"--label=" + containerConfigLabel + "=" + hash,
+		"--label=" + startTimeLabel + "=2024-03-01T12:30:00Z",
+		"--entrypoint=sleep",
+		"--init",
+		"--name=test",
+		"--network=host",
+		"--pid=host",
+		"--rm",
+		"--user=1000:100",
+		"--uts=host",
+		"--workdir=/",
+		"--read-only=false",
+		"alpine",
+		"infinity",
+	}, got); diff != "" {
+		t.Errorf("persistentCreateCommand() diff (-want +got):\n%s", diff)
+	}
+
+	// Settings applied per invocation don't affect the configuration.
+	other := spec
+	other.workdir = "/src/sub"
+	other.entrypoint = "go"
+	other.env = nil
+	other.tty = false
+	other.labels = map[string]string{startTimeLabel: "2024-03-01T13:00:00Z"}
+
+	if _, otherHash, err := b.persistentCreateCommand(&r, &other); err != nil {
+		t.Errorf("persistentCreateCommand() failed: %v", err)
+	} else if otherHash != hash {
+		t.Errorf("Configuration hash changed from %q to %q", hash, otherHash)
+	}
+
+	other = spec
+	other.mounts = []bindMount{{src: "/data", dst: "/data"}}
+
+	if _, otherHash, err := b.persistentCreateCommand(&r, &other); err != nil {
+		t.Errorf("persistentCreateCommand() failed: %v", err)
+	} else if otherHash == hash {
+		t.Errorf("Configuration hash %q unchanged after modifying mounts", hash)
+	}
+}
+
+func TestCheckPersistentMounts(t *testing.T) {
+	r := &runtime{}
+	t.Cleanup(func() { r.cleanup() })
+
+	sockDir, err := r.createDir("dbus")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mounts := newMountSet()
+	mounts.set("/src", mountReadWrite, mountOriginDefault)
+	mounts.set(r.baseDir+"-other", mountReadOnly, mountOriginFlag)
+
+	if err := checkPersistentMounts(r, mounts); err != nil {
+		t.Errorf("checkPersistentMounts() failed: %v", err)
+	}
+
+	mounts.set(filepath.Join(sockDir, "socket"), mountReadOnly, mountOriginDBus)
+
+	if diff := cmp.Diff(cmpopts.AnyError, checkPersistentMounts(r, mounts), cmpopts.EquateErrors()); diff != "" {
+		t.Errorf("checkPersistentMounts() error diff (-want +got):\n%s", diff)
+	}
+}
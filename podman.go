@@ -0,0 +1,39 @@
+package main
+
+import "os"
+
+// podmanUserNamespaceFlags returns the flags mapping the invoking user to the
+// same user ID within the container. Rootless Podman maps the container user
+// to a subordinate ID otherwise, causing files written to bind mounts to be
+// owned by a different host user.
+func podmanUserNamespaceFlags(uid int) []string {
+	if uid == 0 {
+		// "keep-id" is only supported in rootless mode.
+		return nil
+	}
+
+	return []string{"--userns=keep-id"}
+}
+
+// newPodmanBackend returns a backend for Podman. The command line interface is
+// compatible with Docker. Podman also follows Docker's conventions for its own
+// exit codes.
+//
+// https://docs.podman.io/en/latest/markdown/podman-run.1.html#exit-status
+func newPodmanBackend(program string, uid int) *dockerBackend {
+	b := newDockerBackend(program)
+	b.extraFlags = podmanUserNamespaceFlags(uid)
+
+	if len(b.extraFlags) > 0 {
+		// Only the invoking user is mapped within the user namespace.
+		b.keepGroups = true
+
+		// Failures only affect the validation of forwarded groups.
+		b.hostGroups, _ = os.Getgroups()
+	}
+
+	// Podman fails when creating a volume which already exists.
+	b.volumeCreateFlags = []string{"--ignore"}
+
+	return b
+}
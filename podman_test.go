@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestNewPodmanBackend(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		uid            int
+		wantExtraFlags []string
+		wantKeepGroups bool
+	}{
+		{
+			name:           "rootless",
+			uid:            1000,
+			wantExtraFlags: []string{"--userns=keep-id"},
+			wantKeepGroups: true,
+		},
+		{
+			name: "rootful",
+			uid:  0,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := newPodmanBackend("podman", tc.uid)
+
+			if diff := cmp.Diff(tc.wantExtraFlags, b.extraFlags, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("Extra flags diff (-want +got):\n%s", diff)
+			}
+
+			if b.keepGroups != tc.wantKeepGroups {
+				t.Errorf("keepGroups is %t, want %t", b.keepGroups, tc.wantKeepGroups)
+			}
+
+			if diff := cmp.Diff(dockerExitCodes, b.exitCodes); diff != "" {
+				t.Errorf("Exit codes diff (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff([]string{"--ignore"}, b.volumeCreateFlags); diff != "" {
+				t.Errorf("Volume creation flags diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
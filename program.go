@@ -10,8 +10,10 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"slices"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -50,17 +52,59 @@ type program struct {
 	stdout io.Writer
 	stderr io.Writer
 
+	containerEngine  string
 	dockerCliProgram string
+	bwrapProgram     string
+	rootfs           string
 
 	xdgDBusProxyProgram      string
 	xdgDBusProxyReadyTimeout time.Duration
 
-	containerName   string
-	image           string
-	user            string
-	group           string
-	readOnly        bool
-	mounts          *mountSet
+	// Local directory containing X11 display sockets.
+	x11SocketDir string
+
+	configFile string
+	profile    string
+	configEnv  envMap
+
+	// Top-level directory of the project, i.e. the directory containing the
+	// configuration file or the working directory.
+	projectDir string
+
+	containerName string
+	persistent    bool
+	image         string
+	buildContext  string
+	dockerfile    string
+	pullPolicy    string
+
+	// Images referenced by the configuration file, including those of all
+	// profiles.
+	configImages []string
+
+	user  string
+	group string
+
+	// Home directory of a container user other than the local user. Empty
+	// if the user is only defined within the image. Nil for the local user.
+	userHome *string
+
+	readOnly         bool
+	synthesizePasswd bool
+	forwardGroups    bool
+	groupFilter      []string
+	groupAdd         []string
+	mounts           *mountSet
+	tmpfs            *tmpfsSet
+	mountTmp         bool
+	volumes          *volumeSet
+
+	volumeProjectScope bool
+	pruneAllVolumes    bool
+
+	execContainerName string
+	execAllProjects   bool
+
 	workdir         string
 	envFiles        []string
 	env             []string
@@ -68,16 +112,33 @@ type program struct {
 	args            []string
 	interactive     bool
 	forwardSSHAgent bool
+	sshAgentKeys    []string
+	sshAgentConfirm bool
+	forwardGPGAgent bool
+	forwardX11      bool
+	forwardWayland  bool
+	forwardAudio    bool
 	forwardDBus     bool
-	forwardLocale   bool
+	dbusPolicy      dbusPolicy
+	dbusPresets     []string
+
+	forwardSystemDBus bool
+	systemDBusPolicy  dbusPolicy
+	forwardLocale     bool
+	stopTimeout       time.Duration
+	dryRun            bool
+	printSpecFormat   string
 }
 
 func newProgram() *program {
 	return &program{
-		stdin:  os.Stdin,
-		stdout: os.Stdout,
-		stderr: os.Stderr,
-		mounts: newMountSet(),
+		stdin:        os.Stdin,
+		stdout:       os.Stdout,
+		stderr:       os.Stderr,
+		x11SocketDir: x11SocketDir,
+		mounts:       newMountSet(),
+		tmpfs:        newTmpfsSet(),
+		volumes:      newVolumeSet(),
 	}
 }
 
@@ -88,7 +149,7 @@ func applyDefaultMounts(s *mountSet) error {
 		"/etc/localtime",
 		"/etc/passwd",
 	} {
-		s.set(path, mountReadOnly)
+		s.set(path, mountReadOnly, mountOriginDefault)
 	}
 
 	home, err := os.UserHomeDir()
@@ -96,16 +157,32 @@ func applyDefaultMounts(s *mountSet) error {
 		return fmt.Errorf("getting home dir: %w", err)
 	}
 
-	s.set(home, mountReadOnly)
+	return applyHomeMounts(s, home)
+}
 
-	for path, mode := range map[string]mountMode{
+// homeMounts returns the default mounts within a home directory.
+func homeMounts(home string) map[string]mountMode {
+	return map[string]mountMode{
+		home:                        mountReadOnly,
 		filepath.Join(home, ".ssh"): mountReadWrite,
-	} {
+	}
+}
+
+// applyHomeMounts mounts a home directory and its subdirectories if they
+// exist. Destinations in use already are skipped.
+func applyHomeMounts(s *mountSet, home string) error {
+	for path, mode := range homeMounts(home) {
+		if s.has(path) {
+			continue
+		}
+
 		if ok, err := fileExists(path); err != nil {
 			return err
-		} else if ok {
-			s.set(path, mode)
+		} else if !ok {
+			continue
 		}
+
+		s.set(path, mode, mountOriginDefault)
 	}
 
 	return nil
@@ -129,19 +206,30 @@ func (p *program) detectDefaults() error {
 		return nil
 	}
 
-	p.mounts.set(workdir, mountReadWrite)
+	p.mounts.set(workdir, mountReadWrite, mountOriginDefault)
 
 	return nil
 }
 
 func (p *program) registerFlags(app *kingpin.Application) {
-	app.Help = `Run command or shell within a container while preserving most of the local execution environment.`
+	app.Help = `Run command or shell within a container while preserving most of the local execution environment. Use "run" explicitly if the command name is the same as one of cocoon's commands.`
+
+	app.Flag("runtime",
+		`Container runtime. "auto" detects Podman from the name of the CLI program and uses Docker otherwise. "bwrap" uses bubblewrap with a root filesystem given via "--rootfs".`).
+		Envar("COCOON_RUNTIME").
+		Default(string(engineAuto)).
+		EnumVar(&p.containerEngine, containerEngineNames...)
 
-	app.Flag("docker-cli-program", "Name of Docker CLI program or an absolute path.").
+	app.Flag("docker-cli-program", "Name of container runtime CLI program or an absolute path. Defaults to the runtime name or \"docker\".").
+		PlaceHolder("PROGRAM").
 		Envar("COCOON_DOCKER_CLI_PROGRAM").
-		Default("docker").
 		StringVar(&p.dockerCliProgram)
 
+	app.Flag("bwrap-program", "Name of bubblewrap program or an absolute path.").
+		Envar("COCOON_BWRAP_PROGRAM").
+		Default("bwrap").
+		StringVar(&p.bwrapProgram)
+
 	app.Flag("xdg-dbus-proxy-program", "Name of xdg-dbus-proxy program or an absolute path.").
 		Envar("COCOON_XDG_DBUS_PROXY_PROGRAM").
 		Default("xdg-dbus-proxy").
@@ -152,22 +240,63 @@ func (p *program) registerFlags(app *kingpin.Application) {
 		Default("10s").
 		DurationVar(&p.xdgDBusProxyReadyTimeout)
 
-	app.Flag("container-name", "Container name. The default value includes the user ID, directory name and PID.").
+	app.Flag("config",
+		fmt.Sprintf(`Configuration file. Defaults to the first %q file found in the working directory or its parents up to the top of a Git repository.`, configFileName)).
+		PlaceHolder("FILE").
+		Envar("COCOON_CONFIG").
+		ExistingFileVar(&p.configFile)
+
+	app.Flag("profile",
+		fmt.Sprintf(`Name of configuration profile. Defaults to %q if the profile is defined.`, defaultProfileName)).
+		PlaceHolder("NAME").
+		Envar("COCOON_PROFILE").
+		StringVar(&p.profile)
+
+	app.Flag("container-name", "Container name. The default value includes the user ID, directory name and PID or, for persistent containers, a hash of the project directory and image.").
 		Envar("COCOON_CONTAINER_NAME").
 		Default(p.containerName).
 		StringVar(&p.containerName)
 
+	app.Flag("persistent",
+		`Keep the container running after the command finishes and reuse it for subsequent invocations using "exec". The container is recreated when its mounts, image or other settings change.`).
+		Envar("COCOON_PERSISTENT").
+		BoolVar(&p.persistent)
+
 	app.Flag("image", `OCI image name and an optional tag, e.g. "docker.io/library/alpine:latest"`).
 		Envar("COCOON_IMAGE").
-		Required().
 		StringVar(&p.image)
 
-	app.Flag("user", "User name or ID within the container.").
+	app.Flag("pull",
+		fmt.Sprintf(`Image pull policy. "daily" pulls at most once per day unless the image is missing. Images are pinned to the digests recorded in a %q file next to the configuration file, see "lock update".`, lockFileName)).
+		PlaceHolder("POLICY").
+		Envar("COCOON_PULL").
+		Default(string(pullMissing)).
+		EnumVar(&p.pullPolicy, pullPolicyNames...)
+
+	app.Flag("build-context",
+		`Build the image from a Dockerfile using the given context directory. The image is tagged with a hash of the Dockerfile and the context and only built when the tag doesn't exist. Takes precedence over "--image".`).
+		PlaceHolder("DIR").
+		Envar("COCOON_BUILD_CONTEXT").
+		StringVar(&p.buildContext)
+
+	app.Flag("dockerfile",
+		`Dockerfile for building the image. Defaults to "Dockerfile" within the build context. The build context defaults to the directory containing the Dockerfile.`).
+		PlaceHolder("PATH").
+		Envar("COCOON_DOCKERFILE").
+		StringVar(&p.dockerfile)
+
+	app.Flag("rootfs",
+		`Root filesystem for the "bwrap" runtime. Either a directory or a tar archive, e.g. written by "docker export". Archives are extracted to the user's cache directory.`).
+		PlaceHolder("PATH").
+		Envar("COCOON_ROOTFS").
+		StringVar(&p.rootfs)
+
+	app.Flag("user", "User name or ID within the container. Local users are mapped to the same ID.").
 		Hidden().
 		Default(p.user).
 		StringVar(&p.user)
 
-	app.Flag("group", "Group name or ID within the container.").
+	app.Flag("group", "Group name or ID within the container. Defaults to the primary group of the user.").
 		Hidden().
 		Default(p.group).
 		StringVar(&p.group)
@@ -176,19 +305,66 @@ func (p *program) registerFlags(app *kingpin.Application) {
 		Default("true").
 		BoolVar(&p.readOnly)
 
+	app.Flag("synthesize-passwd",
+		`Mount files combining the image's /etc/passwd and /etc/group with entries for the local user and their groups instead of the local files.`).
+		Envar("COCOON_SYNTHESIZE_PASSWD").
+		BoolVar(&p.synthesizePasswd)
+
+	app.Flag("forward-groups", "Add the supplementary groups of the local user to the container process.").
+		Envar("COCOON_FORWARD_GROUPS").
+		BoolVar(&p.forwardGroups)
+
+	app.Flag("group-filter",
+		`Only forward supplementary groups matching the name or ID. The flag can be given multiple times.`).
+		PlaceHolder("NAME|GID").
+		Envar("COCOON_GROUP_FILTER").
+		StringsVar(&p.groupFilter)
+
+	app.Flag("group-add",
+		`Add a supplementary group to the container process. Names are resolved within the container, e.g. for groups only defined by the image. The flag can be given multiple times.`).
+		PlaceHolder("NAME|GID").
+		Envar("COCOON_GROUP_ADD").
+		StringsVar(&p.groupAdd)
+
 	mountSetVar(
 		app.Flag("mount",
-			fmt.Sprintf(`Mount a path into the container in read-only mode. Multiple paths can be specified by passing the flag more than once or by separating paths using %q.`, filepath.ListSeparator)).
-			PlaceHolder("PATH").
+			`Mount a path into the container in read-only mode. Use "SRC:DST" to mount at a different location within the container and an optional ":ro" or ":rw" suffix to choose the mode. Multiple paths can be specified by passing the flag more than once or by separating paths using newlines in the environment variable.`).
+			PlaceHolder("SRC[:DST[:ro|rw]]").
 			Envar("COCOON_MOUNT"),
 		p.mounts, mountReadOnly)
 
 	mountSetVar(
 		app.Flag("mount-rw", `Mount a path in read-write mode. See "--mount" for additional details.`).
-			PlaceHolder("PATH").
+			PlaceHolder("SRC[:DST[:ro|rw]]").
 			Envar("COCOON_MOUNT_RW"),
 		p.mounts, mountReadWrite)
 
+	tmpfsSetVar(
+		app.Flag("mount-tmpfs",
+			`Mount a temporary filesystem. Supported options are "size" with an optional "k", "m" or "g" suffix, "mode" in octal notation, "exec" and "noexec", e.g. "/var/tmp:size=1g,mode=1777". The flag can be given multiple times.`).
+			PlaceHolder("PATH[:OPTIONS]").
+			Envar("COCOON_MOUNT_TMPFS"),
+		p.tmpfs)
+
+	app.Flag("mount-tmp",
+		fmt.Sprintf(`Mount a temporary filesystem at %[1]s unless the path is bind-mounted. Enabled by default. Use "--mount-tmpfs=%[1]s:OPTIONS" to change its options.`, defaultTmpfsPath)).
+		Envar("COCOON_MOUNT_TMP").
+		Default("true").
+		BoolVar(&p.mountTmp)
+
+	volumeSetVar(
+		app.Flag("volume",
+			`Mount a named volume managed by the container runtime, e.g. for persistent caches. Volumes are created as needed and writable unless the "ro" mode is given. The flag can be given multiple times.`).
+			PlaceHolder("NAME:DST[:MODE]").
+			Envar("COCOON_VOLUME"),
+		p.volumes)
+
+	app.Flag("volume-project-scope",
+		`Prefix volume names with "cocoon-" and an identifier for the project directory. Enabled by default.`).
+		Envar("COCOON_VOLUME_PROJECT_SCOPE").
+		Default("true").
+		BoolVar(&p.volumeProjectScope)
+
 	app.Flag("workdir",
 		`Working directory within the container. Defaults to current working directory.`).
 		PlaceHolder("DIR").
@@ -221,16 +397,171 @@ func (p *program) registerFlags(app *kingpin.Application) {
 		Default("true").
 		BoolVar(&p.forwardSSHAgent)
 
+	app.Flag("ssh-agent-key",
+		`Only expose SSH agent keys matching the fingerprint, e.g. "SHA256:...", or the comment pattern. Requests to add or remove keys are refused when given. The flag can be given multiple times.`).
+		PlaceHolder("FINGERPRINT|COMMENT").
+		Envar("COCOON_SSH_AGENT_KEY").
+		StringsVar(&p.sshAgentKeys)
+
+	app.Flag("ssh-agent-confirm",
+		`Ask for confirmation using the program named by SSH_ASKPASS whenever the container uses an SSH agent key.`).
+		Envar("COCOON_SSH_AGENT_CONFIRM").
+		BoolVar(&p.sshAgentConfirm)
+
+	app.Flag("forward-gpg-agent",
+		`Expose the extra socket of the local GnuPG agent and the public keyring to the container. GNUPGHOME is set to a temporary directory.`).
+		Envar("COCOON_FORWARD_GPG_AGENT").
+		BoolVar(&p.forwardGPGAgent)
+
+	app.Flag("forward-x11",
+		`Expose the X11 display named by DISPLAY to the container. A copy of the display's Xauthority cookie is made available via XAUTHORITY.`).
+		Envar("COCOON_FORWARD_X11").
+		BoolVar(&p.forwardX11)
+
+	app.Flag("forward-wayland",
+		`Expose the socket of the local Wayland compositor to the container. XDG_RUNTIME_DIR is set to a directory containing only forwarded sockets.`).
+		Envar("COCOON_FORWARD_WAYLAND").
+		BoolVar(&p.forwardWayland)
+
+	app.Flag("forward-audio",
+		`Expose the local PulseAudio and PipeWire sockets to the container. XDG_RUNTIME_DIR is set to a directory containing only forwarded sockets.`).
+		Envar("COCOON_FORWARD_AUDIO").
+		BoolVar(&p.forwardAudio)
+
 	app.Flag("forward-dbus", "Expose local D-Bus container.").
 		Envar("COCOON_FORWARD_DBUS").
 		BoolVar(&p.forwardDBus)
 
+	app.Flag("dbus-talk",
+		`Restrict the D-Bus proxy and allow talking to the given name. Enables filtering like all other "--dbus-*" flags. The flag can be given multiple times.`).
+		PlaceHolder("NAME").
+		Envar("COCOON_DBUS_TALK").
+		StringsVar(&p.dbusPolicy.talk)
+
+	app.Flag("dbus-own", `Restrict the D-Bus proxy and allow owning the given name.`).
+		PlaceHolder("NAME").
+		Envar("COCOON_DBUS_OWN").
+		StringsVar(&p.dbusPolicy.own)
+
+	app.Flag("dbus-see", `Restrict the D-Bus proxy and allow seeing the given name.`).
+		PlaceHolder("NAME").
+		Envar("COCOON_DBUS_SEE").
+		StringsVar(&p.dbusPolicy.see)
+
+	app.Flag("dbus-call",
+		`Restrict the D-Bus proxy and allow calls matching the rule, e.g. "org.freedesktop.Notifications=org.freedesktop.Notifications.*@/org/freedesktop/Notifications".`).
+		PlaceHolder("NAME=RULE").
+		Envar("COCOON_DBUS_CALL").
+		StringsVar(&p.dbusPolicy.call)
+
+	app.Flag("dbus-preset",
+		fmt.Sprintf(`Restrict the D-Bus proxy and allow access to a well-known service. Available presets: %s.`, strings.Join(dbusPresetNames, ", "))).
+		PlaceHolder("NAME").
+		Envar("COCOON_DBUS_PRESET").
+		EnumsVar(&p.dbusPresets, dbusPresetNames...)
+
+	app.Flag("forward-system-dbus",
+		fmt.Sprintf(`Expose the local D-Bus system bus to the container using a separate proxy. The bus is named by DBUS_SYSTEM_BUS_ADDRESS and defaults to %q.`, dbusDefaultSystemBusAddress)).
+		Envar("COCOON_FORWARD_SYSTEM_DBUS").
+		BoolVar(&p.forwardSystemDBus)
+
+	app.Flag("system-dbus-talk",
+		`Restrict the system bus proxy and allow talking to the given name. Enables filtering like all other "--system-dbus-*" flags. The flag can be given multiple times.`).
+		PlaceHolder("NAME").
+		Envar("COCOON_SYSTEM_DBUS_TALK").
+		StringsVar(&p.systemDBusPolicy.talk)
+
+	app.Flag("system-dbus-own", `Restrict the system bus proxy and allow owning the given name.`).
+		PlaceHolder("NAME").
+		Envar("COCOON_SYSTEM_DBUS_OWN").
+		StringsVar(&p.systemDBusPolicy.own)
+
+	app.Flag("system-dbus-see", `Restrict the system bus proxy and allow seeing the given name.`).
+		PlaceHolder("NAME").
+		Envar("COCOON_SYSTEM_DBUS_SEE").
+		StringsVar(&p.systemDBusPolicy.see)
+
+	app.Flag("system-dbus-call", `Restrict the system bus proxy and allow calls matching the rule. See "--dbus-call".`).
+		PlaceHolder("NAME=RULE").
+		Envar("COCOON_SYSTEM_DBUS_CALL").
+		StringsVar(&p.systemDBusPolicy.call)
+
 	app.Flag("forward-locale", "Set LC_* environment variables in container.").
 		Envar("COCOON_FORWARD_LOCALE").
 		BoolVar(&p.forwardLocale)
 
-	app.Arg("command", "Command and its arguments. If omitted a shell is started.").
+	app.Flag("stop-timeout",
+		`Grace period for the container to terminate after cocoon received SIGINT, SIGTERM or SIGHUP. The signal is forwarded to the container which is stopped forcibly afterwards.`).
+		PlaceHolder("DURATION").
+		Envar("COCOON_STOP_TIMEOUT").
+		Default("10s").
+		DurationVar(&p.stopTimeout)
+
+	app.Flag("dry-run",
+		`Print the container command and the environment in the format used by the runtime instead of running the container.`).
+		BoolVar(&p.dryRun)
+
+	app.Flag("print-spec",
+		`Print the resolved run specification, including where mounts and environment variables originate from, instead of running the container.`).
+		PlaceHolder("FORMAT").
+		EnumVar(&p.printSpecFormat, specFormatNames...)
+
+	run := app.Command(runCommand, "Run command or shell within a container. This is the default command.").
+		Default()
+
+	run.Arg("command", "Command and its arguments. If omitted a shell is started.").
+		StringsVar(&p.args)
+
+	execCmd := app.Command(execCommand, "Run command or shell within a running cocoon container of the current project, e.g. to inspect the state of a long build.")
+
+	execCmd.Flag("container", "Name of the container. Asks for a choice if multiple containers are running and a terminal is available.").
+		PlaceHolder("NAME").
+		StringVar(&p.execContainerName)
+
+	execCmd.Flag("all", "Consider containers of any project.").
+		BoolVar(&p.execAllProjects)
+
+	execCmd.Arg("command", "Command and its arguments. If omitted a shell is started.").
 		StringsVar(&p.args)
+
+	app.Command(psCommand, "List containers created by cocoon including the process which started them.")
+
+	app.Command(cleanCommand, "Remove containers and temporary directories left behind by cocoon processes which no longer exist.")
+
+	lock := app.Command("lock", "Manage the image lock file.")
+
+	lock.Command("update", fmt.Sprintf("Pull the images referenced by the configuration, including all profiles, and record their digests in the %q file.", lockFileName))
+
+	volumes := app.Command("volumes", "Manage named volumes.")
+
+	prune := volumes.Command("prune", "Remove volumes created for the current project.")
+
+	prune.Flag("all", "Remove volumes created by cocoon for any project.").
+		BoolVar(&p.pruneAllVolumes)
+
+	app.Action(func(c *kingpin.ParseContext) error {
+		explicit := explicitFlags(app, c)
+
+		if err := p.loadConfig(explicit); err != nil {
+			return err
+		}
+
+		if err := p.resolveUser(explicit); err != nil {
+			return err
+		}
+
+		if !explicit["container-name"] {
+			if p.persistent {
+				p.containerName = persistentContainerName(os.Getuid(), p.projectDir, p.image)
+			} else if p.dryRun {
+				// The default name contains the process ID. The runtime
+				// chooses a name instead.
+				p.containerName = ""
+			}
+		}
+
+		return nil
+	})
 }
 
 type runtime struct {
@@ -249,7 +580,7 @@ func (r *runtime) cleanup() error {
 
 func (r *runtime) ensureBaseDir() (string, error) {
 	if r.baseDir == "" {
-		path, err := os.MkdirTemp("", "tmp-cocoon-*")
+		path, err := os.MkdirTemp("", fmt.Sprintf("%s%d-*", runtimeDirPrefix, os.Getpid()))
 		if err != nil {
 			return "", err
 		}
@@ -278,27 +609,173 @@ func (r *runtime) createDir(pattern string) (string, error) {
 	return os.MkdirTemp(baseDir, pattern)
 }
 
-func createTempEnvFile(r *runtime, environ envMap) (_ string, err error) {
+// createTempEnvFile writes the environment variables to a temporary file in
+// the format of the backend. An empty name is returned without variables.
+func createTempEnvFile(r *runtime, backend containerBackend, environ envMap) (_ string, err error) {
 	if len(environ) == 0 {
 		return "", nil
 	}
 
 	f, err := r.createFile("env")
+	if err != nil {
+		return "", err
+	}
+
 	defer func() {
 		err = errors.Join(err, f.Close())
 	}()
 
-	if err := writeDockerEnviron(f, environ); err != nil {
+	if err := backend.writeEnviron(f, environ); err != nil {
 		return "", fmt.Errorf("writing environment to %q: %w", f.Name(), err)
 	}
 
 	return f.Name(), nil
 }
 
+// tmpfsMounts returns the temporary filesystems including the default at
+// /tmp. The default is omitted when the path is bind-mounted.
+func (p *program) tmpfsMounts(mounts *mountSet) ([]tmpfsMount, error) {
+	tmpfs := p.tmpfs.clone()
+
+	if p.mountTmp && !tmpfs.has(defaultTmpfsPath) && !mounts.has(defaultTmpfsPath) && !p.volumes.has(defaultTmpfsPath) {
+		tmpfs.set(tmpfsMount{path: defaultTmpfsPath})
+	}
+
+	if err := tmpfs.checkShadowing(mounts, p.volumes); err != nil {
+		return nil, err
+	}
+
+	return tmpfs.list(), nil
+}
+
+const (
+	runCommand          = "run"
+	execCommand         = "exec"
+	psCommand           = "ps"
+	cleanCommand        = "clean"
+	lockUpdateCommand   = "lock update"
+	volumesPruneCommand = "volumes prune"
+)
+
+// execute invokes the implementation of a command as returned by
+// kingpin.Application.Parse.
+func (p *program) execute(ctx context.Context, command string) error {
+	switch command {
+	case execCommand:
+		return p.execContainer(ctx)
+	case psCommand:
+		return p.listCocoonContainers(ctx)
+	case cleanCommand:
+		return p.clean(ctx)
+	case lockUpdateCommand:
+		return p.updateLock(ctx)
+	case volumesPruneCommand:
+		return p.pruneVolumes(ctx)
+	}
+
+	return p.run(ctx)
+}
+
+// toRunSpec combines the program settings with the final mounts and
+// environment.
+func (p *program) toRunSpec(mounts *mountSet, env envMap) (*runSpec, error) {
+	tmpfs, err := p.tmpfsMounts(mounts)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &runSpec{
+		name:       p.containerName,
+		image:      p.image,
+		user:       p.user,
+		group:      p.group,
+		workdir:    p.workdir,
+		readOnly:   p.readOnly,
+		mounts:     mounts.list(),
+		tmpfs:      tmpfs,
+		volumes:    p.volumeMounts(),
+		projectDir: p.projectDir,
+		env:        env,
+		tty:        p.interactive,
+		entrypoint: p.shell,
+	}
+
+	var hostGroups []hostGroup
+
+	if p.forwardGroups {
+		if hostGroups, err = currentHostGroups(); err != nil {
+			return nil, err
+		}
+	}
+
+	spec.forwardedGroups, spec.groups = p.supplementaryGroups(hostGroups)
+
+	if len(p.args) > 0 {
+		spec.entrypoint = p.args[0]
+		spec.args = p.args[1:]
+	}
+
+	return spec, nil
+}
+
+// simulated reports whether the container is only described instead of
+// being run.
+func (p *program) simulated() bool {
+	return p.dryRun || p.printSpecFormat != ""
+}
+
+// dryRunRuntimeDir replaces the runtime's base directory in printed commands.
+const dryRunRuntimeDir = "RUNTIME_DIR"
+
+// printDryRun writes the shell-quoted container command. Paths of temporary
+// files and directories, e.g. forwarded sockets, vary between invocations and
+// are replaced by their name within a placeholder directory.
+func (p *program) printDryRun(r *runtime, args []string) error {
+	if r.baseDir != "" {
+		tempPath := regexp.MustCompile(regexp.QuoteMeta(r.baseDir) + `/([^/,:=]*?)\d+`)
+
+		args = slices.Clone(args)
+
+		for idx, arg := range args {
+			args[idx] = tempPath.ReplaceAllString(arg, dryRunRuntimeDir+"/${1}")
+		}
+	}
+
+	_, err := fmt.Fprintln(p.stdout, shellquote.Join(args...))
+
+	return err
+}
+
 func (p *program) run(ctx context.Context) (err error) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	// Prepared before capturing signals to keep builds and pulls
+	// interruptible.
+	if err := p.prepareImage(ctx); err != nil {
+		return err
+	}
+
+	signals, stopSignals := notifyTermination()
+	defer stopSignals()
+
+	backend, err := p.newBackend()
+	if err != nil {
+		return err
+	}
+
+	var persistent *dockerBackend
+
+	if p.persistent {
+		if persistent, err = persistentBackend(backend); err != nil {
+			return err
+		}
+	}
+
+	if err := p.checkImageUser(ctx, backend); err != nil {
+		return err
+	}
+
 	r := &runtime{}
 
 	defer func() {
@@ -307,25 +784,69 @@ func (p *program) run(ctx context.Context) (err error) {
 		}
 	}()
 
-	baseEnv := envMap{
-		"HOME": nil,
+	baseEnv := p.baseEnviron()
+
+	mounts := p.mounts.clone()
+
+	if p.synthesizePasswd {
+		id, err := localPasswdIdentity(p.user)
+		if err != nil {
+			return fmt.Errorf("passwd: %w", err)
+		}
+
+		if err := p.setupPasswd(ctx, r, backend, mounts, id); err != nil {
+			return fmt.Errorf("passwd: %w", err)
+		}
 	}
 
-	if p.interactive {
-		baseEnv["debian_chroot"] = &p.containerName
+	if p.forwardSSHAgent {
+		if sshAuthSock := os.Getenv(sshAuthSockEnv); sshAuthSock != "" {
+			if p.sshAgentFiltered() {
+				proxySocket, proxyCleanup, err := p.startSSHAgentProxy(r, sshAuthSock)
+				if err != nil {
+					return fmt.Errorf("SSH agent: %w", err)
+				}
+
+				defer func() {
+					if proxyErr := proxyCleanup(); proxyErr != nil {
+						err = errors.Join(err, fmt.Errorf("SSH agent proxy: %w", proxyErr))
+					}
+				}()
+
+				sshAuthSock = proxySocket
+			}
+
+			mounts.set(sshAuthSock, mountReadOnly, mountOriginSSHAgent)
+			baseEnv[sshAuthSockEnv] = &sshAuthSock
+		}
 	}
 
-	mounts := p.mounts.clone()
+	if p.forwardGPGAgent {
+		if err := p.setupGPGAgent(r, mounts, baseEnv); err != nil {
+			return fmt.Errorf("GnuPG agent: %w", err)
+		}
+	}
 
-	if p.forwardSSHAgent {
-		if sshAuthSock := os.Getenv("SSH_AUTH_SOCK"); sshAuthSock != "" {
-			mounts.set(sshAuthSock, mountReadOnly)
-			baseEnv["SSH_AUTH_SOCK"] = &sshAuthSock
+	if p.forwardX11 {
+		if err := p.setupX11(r, mounts, baseEnv); err != nil {
+			return fmt.Errorf("X11: %w", err)
+		}
+	}
+
+	if p.forwardWayland {
+		if err := p.setupWayland(r, mounts, baseEnv); err != nil {
+			return fmt.Errorf("Wayland: %w", err)
+		}
+	}
+
+	if p.forwardAudio {
+		if err := p.setupAudio(r, mounts, baseEnv); err != nil {
+			return fmt.Errorf("audio: %w", err)
 		}
 	}
 
 	if p.forwardDBus {
-		dbusSocket, dbusCleanup, err := p.startDBusProxy(ctx, r)
+		dbusSocket, dbusCleanup, err := p.startSessionBusProxy(ctx, r)
 		if err != nil {
 			return fmt.Errorf("D-Bus: %w", err)
 		}
@@ -336,31 +857,134 @@ func (p *program) run(ctx context.Context) (err error) {
 			}
 		}()
 
-		mounts.set(dbusSocket, mountReadOnly)
-		baseEnv[dbusSessionBusAddressEnv] = &dbusSocket
+		address := "unix:path=" + dbusSocket
+
+		mounts.set(dbusSocket, mountReadOnly, mountOriginDBus)
+		baseEnv[dbusSessionBusAddressEnv] = &address
 	}
 
-	if p.forwardLocale {
-		for _, i := range localeEnvVariables {
-			baseEnv[i] = nil
+	if p.forwardSystemDBus {
+		dbusSocket, dbusCleanup, err := p.startSystemBusProxy(ctx, r)
+		if err != nil {
+			return fmt.Errorf("D-Bus system bus: %w", err)
 		}
+
+		defer func() {
+			if dbusErr := dbusCleanup(); dbusErr != nil {
+				err = errors.Join(err, fmt.Errorf("D-Bus system bus proxy: %w", dbusErr))
+			}
+		}()
+
+		address := "unix:path=" + dbusSocket
+
+		mounts.set(dbusSocket, mountReadOnly, mountOriginDBus)
+		baseEnv[dbusSystemBusAddressEnv] = &address
 	}
 
-	env, err := combineEnviron(baseEnv, p.envFiles, p.env)
+	if p.persistent {
+		if err := checkPersistentMounts(r, mounts); err != nil {
+			return err
+		}
+	}
+
+	env, envSources, err := p.combineEnviron(baseEnv)
 	if err != nil {
 		return err
 	}
 
-	envFile, err := createTempEnvFile(r, env)
+	spec, err := p.toRunSpec(mounts, env)
 	if err != nil {
 		return err
 	}
 
-	args, err := p.toDockerCommand(envFile, mounts)
+	spec.inlineEnv = p.dryRun
+
+	if spec.labels, err = p.containerLabels(time.Now()); err != nil {
+		return err
+	}
+
+	if p.printSpecFormat != "" {
+		return p.printSpec(p.stdout, spec, mounts, envSources)
+	}
+
+	if !p.dryRun {
+		if err := backend.prepare(ctx, spec); err != nil {
+			return err
+		}
+
+		if persistent != nil {
+			if err := persistent.ensurePersistentContainer(ctx, r, spec); err != nil {
+				return err
+			}
+		}
+	}
+
+	var args []string
+
+	if persistent != nil {
+		args, err = persistent.execCommand(r, spec)
+	} else {
+		args, err = backend.command(r, spec)
+	}
+
 	if err != nil {
 		return err
 	}
 
+	if p.dryRun {
+		return p.printDryRun(r, args)
+	}
+
+	var container string
+
+	if persistent == nil {
+		// Commands run using "exec" can't be signalled via the container.
+		container = spec.name
+	}
+
+	return p.runContainerCommand(ctx, backend, args, signals, container)
+}
+
+// baseEnviron returns the variables set for every command.
+func (p *program) baseEnviron() envMap {
+	env := envMap{}
+
+	if p.userHome == nil {
+		env["HOME"] = nil
+	} else if *p.userHome != "" {
+		env["HOME"] = p.userHome
+	}
+
+	if p.interactive && p.containerName != "" {
+		env["debian_chroot"] = &p.containerName
+	}
+
+	return env
+}
+
+// combineEnviron adds the locale, configured variables, environment files and
+// variables given on the command line to the base variables.
+func (p *program) combineEnviron(baseEnv envMap) (envMap, envSources, error) {
+	if p.forwardLocale {
+		for _, i := range localeEnvVariables {
+			baseEnv[i] = nil
+		}
+	}
+
+	return combineEnviron(baseEnv, p.configEnv, p.envFiles, p.env)
+}
+
+// runContainerCommand invokes the container runtime CLI. The exit status of
+// the command within the container is returned as a commandError. Termination
+// signals are relayed to the named container, if any, or to the CLI. A signal
+// received before the container is started aborts the invocation.
+func (p *program) runContainerCommand(ctx context.Context, backend containerBackend, args []string, signals <-chan os.Signal, container string) error {
+	select {
+	case sig := <-signals:
+		return &commandError{status: signalStatus(sig)}
+	default:
+	}
+
 	if p.interactive {
 		log.Printf("Container command: %s", shellquote.Join(args...))
 	}
@@ -374,10 +998,16 @@ func (p *program) run(ctx context.Context) (err error) {
 		Foreground: isTerminal(p.stdin),
 	}
 
-	if err := cmd.Run(); err != nil {
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("container runtime: %w", err)
+	}
+
+	if sig, err := superviseCommand(ctx, cmd, signals, newSignalTarget(backend, cmd.Process, container), p.stopTimeout); sig != nil {
+		return &commandError{status: signalStatus(sig)}
+	} else if err != nil {
 		var exitErr *exec.ExitError
 
-		if errors.As(err, &exitErr) && !slices.Contains(dockerExitCodes, exitErr.ExitCode()) {
+		if errors.As(err, &exitErr) && !backend.isRuntimeFailure(exitErr.ExitCode()) {
 			return &commandError{status: exitErr.ExitCode()}
 		}
 
@@ -1,13 +1,16 @@
 package main
 
 import (
+	"context"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/creack/pty"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/hansmi/cocoon/internal/ref"
 	"github.com/hansmi/cocoon/internal/testutil"
 )
 
@@ -102,3 +105,258 @@ func TestIsTerminal(t *testing.T) {
 		})
 	}
 }
+
+func TestProgramToRunSpec(t *testing.T) {
+	p := newProgram()
+	p.containerName = "name"
+	p.image = "image"
+	p.user = "user"
+	p.group = "group"
+	p.workdir = "/work"
+	p.readOnly = true
+	p.shell = "/bin/bash"
+	p.interactive = true
+	p.mountTmp = true
+	p.args = []string{"make", "-C", "dir"}
+
+	mounts := newMountSet()
+	mounts.set("/work", mountReadWrite, mountOriginDefault)
+
+	env := envMap{"FOO": ref.Ref("bar")}
+
+	got, err := p.toRunSpec(mounts, env)
+	if err != nil {
+		t.Errorf("toRunSpec() failed: %v", err)
+	}
+
+	if diff := cmp.Diff(&runSpec{
+		name:     "name",
+		image:    "image",
+		user:     "user",
+		group:    "group",
+		workdir:  "/work",
+		readOnly: true,
+		mounts: []bindMount{
+			{src: "/work", dst: "/work", mode: mountReadWrite},
+		},
+		tmpfs: []tmpfsMount{
+			{path: "/tmp"},
+		},
+		env:        env,
+		tty:        true,
+		entrypoint: "make",
+		args:       []string{"-C", "dir"},
+	}, got, cmp.AllowUnexported(runSpec{}, bindMount{}, tmpfsMount{})); diff != "" {
+		t.Errorf("toRunSpec() diff (-want +got):\n%s", diff)
+	}
+
+	p.args = nil
+
+	if got, err := p.toRunSpec(mounts, env); err != nil {
+		t.Errorf("toRunSpec() failed: %v", err)
+	} else if got.entrypoint != p.shell || len(got.args) != 0 {
+		t.Errorf("toRunSpec() without command returned entrypoint %q and arguments %q", got.entrypoint, got.args)
+	}
+}
+
+func TestCreateTempEnvFile(t *testing.T) {
+	environ := envMap{
+		"FOO":  ref.Ref("bar baz"),
+		"PASS": nil,
+	}
+
+	for _, tc := range []struct {
+		name    string
+		backend containerBackend
+		environ envMap
+		want    string
+	}{
+		{
+			name:    "empty",
+			backend: newDockerBackend("docker"),
+		},
+		{
+			name:    "docker",
+			backend: newDockerBackend("docker"),
+			environ: environ,
+			want:    "FOO=bar baz\nPASS\n",
+		},
+		{
+			name:    "bwrap",
+			backend: newBwrapBackend("bwrap", ""),
+			environ: environ,
+			want:    "'FOO=bar baz'\nPASS\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &runtime{}
+			t.Cleanup(func() { r.cleanup() })
+
+			path, err := createTempEnvFile(r, tc.backend, tc.environ)
+			if err != nil {
+				t.Fatalf("createTempEnvFile() failed: %v", err)
+			}
+
+			if path == "" {
+				if tc.want != "" {
+					t.Errorf("createTempEnvFile() returned no file")
+				}
+
+				return
+			}
+
+			content, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tc.want, string(content)); diff != "" {
+				t.Errorf("Environment file diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProgramTmpfsMounts(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		mountTmp bool
+		tmpfs    []string
+		mounts   []string
+		volumes  []string
+		want     []tmpfsMount
+		wantErr  error
+	}{
+		{name: "disabled"},
+		{
+			name:     "default",
+			mountTmp: true,
+			mounts:   []string{"/tmp/socket"},
+			want: []tmpfsMount{
+				{path: "/tmp"},
+			},
+		},
+		{
+			name:     "resize default",
+			mountTmp: true,
+			tmpfs:    []string{"/tmp:size=1g", "/var/tmp"},
+			want: []tmpfsMount{
+				{path: "/tmp", size: 1 << 30},
+				{path: "/var/tmp"},
+			},
+		},
+		{
+			name:     "default replaced by bind mount",
+			mountTmp: true,
+			mounts:   []string{"/tmp"},
+		},
+		{
+			name:    "shadowing",
+			tmpfs:   []string{"/home/user/.cache"},
+			mounts:  []string{"/home/user", "/home/user/.cache"},
+			wantErr: cmpopts.AnyError,
+		},
+		{
+			name:     "default replaced by volume",
+			mountTmp: true,
+			volumes:  []string{"scratch:/tmp"},
+		},
+		{
+			name:    "volume shadowing bind mount",
+			mounts:  []string{"/home/user/.cache"},
+			volumes: []string{"cache:/home/user/.cache"},
+			wantErr: cmpopts.AnyError,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newProgram()
+			p.mountTmp = tc.mountTmp
+
+			for _, i := range tc.tmpfs {
+				m, err := parseTmpfsSpec(i)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				p.tmpfs.set(m)
+			}
+
+			for _, i := range tc.volumes {
+				v, err := parseVolumeSpec(i)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				p.volumes.set(v)
+			}
+
+			mounts := newMountSet()
+
+			for _, i := range tc.mounts {
+				mounts.set(i, mountReadOnly, mountOriginFlag)
+			}
+
+			got, err := p.tmpfsMounts(mounts)
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("tmpfsMounts() error diff (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.want, got, cmp.AllowUnexported(tmpfsMount{}), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("tmpfsMounts() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProgramRunDryRun(t *testing.T) {
+	tmpdir := t.TempDir()
+
+	// The container runtime CLI doesn't need to be installed.
+	t.Setenv("PATH", tmpdir)
+	t.Setenv(dbusSessionBusAddressEnv, "unix:path=/run/user/1000/bus")
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout strings.Builder
+
+	p := newProgram()
+	p.stdout = &stdout
+	p.containerEngine = string(engineDocker)
+	p.xdgDBusProxyProgram = filepath.Join(tmpdir, "missing")
+	p.image = "image"
+	p.user = "1000"
+	p.group = "1000"
+	p.workdir = "/work"
+	p.shell = "/bin/sh"
+	p.forwardDBus = true
+	p.env = []string{"FOO=bar baz"}
+	p.args = []string{"echo", "hello world"}
+	p.dryRun = true
+
+	p.volumes.set(volumeMount{name: "cache", dst: "/cache", mode: mountReadWrite})
+
+	if err := p.run(context.Background()); err != nil {
+		t.Errorf("run() failed: %v", err)
+	}
+
+	want := strings.Join([]string{
+		"docker run --entrypoint=echo --init --network=host --pid=host --rm",
+		"--user=1000:1000 --uts=host --workdir=/work --read-only=false",
+		"--label=" + profileLabel + "=",
+		"--label=" + workdirLabel + "=" + cwd,
+		"--mount=type=bind,src=RUNTIME_DIR/dbus/socket,dst=RUNTIME_DIR/dbus/socket,readonly",
+		"--mount=type=volume,src=cache,dst=/cache",
+		"--env=DBUS_SESSION_BUS_ADDRESS=unix:path=RUNTIME_DIR/dbus/socket",
+		"'--env=FOO=bar baz'",
+		"--env=HOME",
+		"image 'hello world'",
+	}, " ") + "\n"
+
+	if diff := cmp.Diff(want, stdout.String()); diff != "" {
+		t.Errorf("Dry-run output diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// Labels describing the invocation which started a container.
+const (
+	versionLabel   = labelPrefix + "version"
+	pidLabel       = labelPrefix + "pid"
+	workdirLabel   = labelPrefix + "workdir"
+	profileLabel   = labelPrefix + "profile"
+	startTimeLabel = labelPrefix + "start-time"
+)
+
+// Prefix for the names of temporary directories created by runtime. It's
+// followed by the PID of the owning process.
+const runtimeDirPrefix = "tmp-cocoon-"
+
+// cocoonVersion returns the module version embedded at build time.
+func cocoonVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+
+	return "(devel)"
+}
+
+// processExists reports whether a process with the given ID exists. Processes
+// of other users are detected as well.
+func processExists(pid int) bool {
+	err := syscall.Kill(pid, 0)
+
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// containerLabels returns the labels identifying the invocation. Persistent
+// containers outlive their creator and are therefore not associated with a
+// process.
+func (p *program) containerLabels(now time.Time) (map[string]string, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("getting working directory: %w", err)
+	}
+
+	labels := map[string]string{
+		workdirLabel: cwd,
+		profileLabel: p.profile,
+	}
+
+	if p.dryRun {
+		// Values differing between invocations are left out of the printed
+		// command.
+		return labels, nil
+	}
+
+	labels[versionLabel] = cocoonVersion()
+	labels[startTimeLabel] = now.UTC().Format(time.RFC3339)
+
+	if !p.persistent {
+		labels[pidLabel] = strconv.Itoa(os.Getpid())
+	}
+
+	return labels, nil
+}
+
+func dockerLabelFlags(labels map[string]string) []string {
+	var result []string
+
+	for _, key := range slices.Sorted(maps.Keys(labels)) {
+		result = append(result, "--label="+key+"="+labels[key])
+	}
+
+	return result
+}
+
+// Container created by cocoon as reported by the container runtime.
+type cocoonContainer struct {
+	id     string
+	name   string
+	status string
+	labels map[string]string
+}
+
+// pid returns the ID of the process owning the container or zero for
+// persistent containers.
+func (c *cocoonContainer) pid() int {
+	pid, err := strconv.Atoi(c.labels[pidLabel])
+	if err != nil || pid <= 0 {
+		return 0
+	}
+
+	return pid
+}
+
+// orphaned reports whether the process owning the container is gone.
+func (c *cocoonContainer) orphaned(exists func(int) bool) bool {
+	pid := c.pid()
+
+	return pid != 0 && !exists(pid)
+}
+
+// parseDockerInspect extracts the containers from the output of the
+// "inspect" command.
+func parseDockerInspect(data string) ([]cocoonContainer, error) {
+	var entries []struct {
+		ID     string `json:"Id"`
+		Name   string `json:"Name"`
+		Config struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"Config"`
+		State struct {
+			Status string `json:"Status"`
+		} `json:"State"`
+	}
+
+	if err := json.Unmarshal([]byte(data), &entries); err != nil {
+		return nil, fmt.Errorf("parsing container details: %w", err)
+	}
+
+	var result []cocoonContainer
+
+	for _, i := range entries {
+		result = append(result, cocoonContainer{
+			id: i.ID,
+			// Docker prefixes names with a slash.
+			name:   strings.TrimPrefix(i.Name, "/"),
+			status: i.State.Status,
+			labels: i.Config.Labels,
+		})
+	}
+
+	slices.SortFunc(result, func(a, b cocoonContainer) int {
+		return strings.Compare(a.name, b.name)
+	})
+
+	return result, nil
+}
+
+// cocoonContainers returns all containers created by cocoon, including those
+// which are not running.
+func (b *dockerBackend) cocoonContainers(ctx context.Context) ([]cocoonContainer, error) {
+	output, err := b.output(ctx, "ps", "--all", "--quiet", "--filter=label="+projectLabel)
+	if err != nil {
+		return nil, fmt.Errorf("listing containers: %w", err)
+	}
+
+	ids := strings.Fields(output)
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	output, err = b.output(ctx, append([]string{"inspect", "--type=container"}, ids...)...)
+	if err != nil {
+		return nil, fmt.Errorf("inspecting containers: %w", err)
+	}
+
+	return parseDockerInspect(output)
+}
+
+// listCocoonContainers prints the containers created by cocoon together with
+// the invocation which started them.
+func (p *program) listCocoonContainers(ctx context.Context) error {
+	cli, err := p.containerCli()
+	if err != nil {
+		return err
+	}
+
+	containers, err := cli.newBackend().cocoonContainers(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(p.stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "NAME\tSTATUS\tPID\tSTARTED\tPROFILE\tPROJECT\tWORKDIR")
+
+	for _, c := range containers {
+		pid := "-"
+
+		if value := c.pid(); value != 0 {
+			pid = strconv.Itoa(value)
+
+			if c.orphaned(processExists) {
+				pid += " (gone)"
+			}
+		}
+
+		profile := c.labels[profileLabel]
+		if profile == "" {
+			profile = "-"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", c.name, c.status, pid,
+			c.labels[startTimeLabel], profile, c.labels[projectLabel], c.labels[workdirLabel])
+	}
+
+	return w.Flush()
+}
+
+// staleRuntimeDirs returns the temporary directories within the given
+// directory whose owning process is gone. Directories of other users are
+// ignored.
+func staleRuntimeDirs(dir string, uid int, exists func(int) bool) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+
+	for _, entry := range entries {
+		rest, ok := strings.CutPrefix(entry.Name(), runtimeDirPrefix)
+		if !ok || !entry.IsDir() {
+			continue
+		}
+
+		value, _, ok := strings.Cut(rest, "-")
+		if !ok {
+			// Created by a version without the PID in the name.
+			continue
+		}
+
+		pid, err := strconv.Atoi(value)
+		if err != nil || pid <= 0 || exists(pid) {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		if st, ok := info.Sys().(*syscall.Stat_t); !ok || int(st.Uid) != uid {
+			continue
+		}
+
+		result = append(result, filepath.Join(dir, entry.Name()))
+	}
+
+	return result, nil
+}
+
+// clean removes containers and temporary directories left behind by cocoon
+// processes which terminated without cleaning up, e.g. when killed.
+func (p *program) clean(ctx context.Context) error {
+	cli, err := p.containerCli()
+	if err != nil {
+		return err
+	}
+
+	backend := cli.newBackend()
+
+	containers, err := backend.cocoonContainers(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+
+	for _, c := range containers {
+		if !c.orphaned(processExists) {
+			continue
+		}
+
+		if _, err := backend.output(ctx, "rm", "--force", c.id); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		fmt.Fprintln(p.stdout, c.name)
+	}
+
+	dirs, err := staleRuntimeDirs(os.TempDir(), os.Getuid(), processExists)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+
+	for _, path := range dirs {
+		if err := os.RemoveAll(path); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		fmt.Fprintln(p.stdout, path)
+	}
+
+	return errors.Join(errs...)
+}
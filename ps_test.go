@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/hansmi/cocoon/internal/testutil"
+)
+
+func TestProcessExists(t *testing.T) {
+	if !processExists(os.Getpid()) {
+		t.Errorf("processExists(%d) returned false for own process", os.Getpid())
+	}
+
+	// Larger than the maximum PID supported by Linux.
+	if processExists(1 << 30) {
+		t.Errorf("processExists() returned true for non-existent process")
+	}
+}
+
+func TestProgramContainerLabels(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 30, 0, 0, time.UTC)
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := newProgram()
+	p.profile = "release"
+
+	got, err := p.containerLabels(now)
+	if err != nil {
+		t.Errorf("containerLabels() failed: %v", err)
+	}
+
+	want := map[string]string{
+		versionLabel:   cocoonVersion(),
+		pidLabel:       strconv.Itoa(os.Getpid()),
+		workdirLabel:   cwd,
+		profileLabel:   "release",
+		startTimeLabel: "2024-03-01T12:30:00Z",
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("containerLabels() diff (-want +got):\n%s", diff)
+	}
+
+	p.persistent = true
+
+	if got, err := p.containerLabels(now); err != nil {
+		t.Errorf("containerLabels() failed: %v", err)
+	} else if _, ok := got[pidLabel]; ok {
+		t.Errorf("Persistent container labelled with PID: %q", got)
+	}
+
+	p.dryRun = true
+
+	if got, err := p.containerLabels(now); err != nil {
+		t.Errorf("containerLabels() failed: %v", err)
+	} else if diff := cmp.Diff(map[string]string{
+		workdirLabel: cwd,
+		profileLabel: "release",
+	}, got); diff != "" {
+		t.Errorf("containerLabels() for dry-run diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestDockerBackendCocoonContainers(t *testing.T) {
+	var got [][]string
+
+	b := newDockerBackend("docker")
+	b.output = func(_ context.Context, args ...string) (string, error) {
+		got = append(got, args)
+
+		if args[0] == "inspect" {
+			return `[
+				{
+					"Id": "bbb",
+					"Name": "/second",
+					"Config": {"Labels": {"` + pidLabel + `": "123"}},
+					"State": {"Status": "running"}
+				},
+				{
+					"Id": "aaa",
+					"Name": "first",
+					"Config": {"Labels": {"` + profileLabel + `": "debug"}},
+					"State": {"Status": "exited"}
+				}
+			]`, nil
+		}
+
+		return "bbb\naaa\n", nil
+	}
+
+	containers, err := b.cocoonContainers(context.Background())
+	if err != nil {
+		t.Errorf("cocoonContainers() failed: %v", err)
+	}
+
+	if diff := cmp.Diff([]cocoonContainer{
+		{id: "aaa", name: "first", status: "exited", labels: map[string]string{profileLabel: "debug"}},
+		{id: "bbb", name: "second", status: "running", labels: map[string]string{pidLabel: "123"}},
+	}, containers, cmp.AllowUnexported(cocoonContainer{})); diff != "" {
+		t.Errorf("cocoonContainers() diff (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff([][]string{
+		{"ps", "--all", "--quiet", "--filter=label=" + projectLabel},
+		{"inspect", "--type=container", "bbb", "aaa"},
+	}, got); diff != "" {
+		t.Errorf("CLI invocation diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestCocoonContainerOrphaned(t *testing.T) {
+	exists := func(pid int) bool { return pid == 100 }
+
+	for _, tc := range []struct {
+		name   string
+		labels map[string]string
+		want   bool
+	}{
+		{name: "persistent"},
+		{name: "invalid", labels: map[string]string{pidLabel: "abc"}},
+		{name: "alive", labels: map[string]string{pidLabel: "100"}},
+		{name: "gone", labels: map[string]string{pidLabel: "200"}, want: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := cocoonContainer{labels: tc.labels}
+
+			if diff := cmp.Diff(tc.want, c.orphaned(exists)); diff != "" {
+				t.Errorf("orphaned() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestStaleRuntimeDirs(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, name := range []string{
+		"tmp-cocoon-100-1",
+		"tmp-cocoon-200-1",
+		"tmp-cocoon-200-2",
+		"tmp-cocoon-12345",
+		"tmp-cocoon-abc-1",
+		"other-200-1",
+	} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0o700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testutil.MustWriteFile(t, filepath.Join(dir, "tmp-cocoon-300-1"), "")
+
+	exists := func(pid int) bool { return pid == 100 }
+
+	got, err := staleRuntimeDirs(dir, os.Getuid(), exists)
+	if err != nil {
+		t.Errorf("staleRuntimeDirs() failed: %v", err)
+	}
+
+	if diff := cmp.Diff([]string{
+		filepath.Join(dir, "tmp-cocoon-200-1"),
+		filepath.Join(dir, "tmp-cocoon-200-2"),
+	}, got); diff != "" {
+		t.Errorf("staleRuntimeDirs() diff (-want +got):\n%s", diff)
+	}
+
+	if got, err := staleRuntimeDirs(dir, os.Getuid()+1, exists); err != nil {
+		t.Errorf("staleRuntimeDirs() failed: %v", err)
+	} else if diff := cmp.Diff([]string{}, got, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("staleRuntimeDirs() for other user diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type pullPolicy string
+
+const (
+	pullAlways  pullPolicy = "always"
+	pullMissing pullPolicy = "missing"
+	pullNever   pullPolicy = "never"
+	pullDaily   pullPolicy = "daily"
+)
+
+var pullPolicyNames = []string{
+	string(pullAlways),
+	string(pullMissing),
+	string(pullNever),
+	string(pullDaily),
+}
+
+// Minimum amount of time between pulls with the "daily" policy.
+const dailyPullInterval = 24 * time.Hour
+
+// pullStampPath returns the file recording the time of the last pull of an
+// image reference.
+func pullStampPath(ref string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("pull cache: %w", err)
+	}
+
+	sum := sha256.Sum256([]byte(ref))
+
+	return filepath.Join(cacheDir, "cocoon", "pull", hex.EncodeToString(sum[:])), nil
+}
+
+// pullDue reports whether the stamp file is missing or older than the daily
+// pull interval.
+func pullDue(stamp string, now time.Time) (bool, error) {
+	info, err := os.Stat(stamp)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return true, nil
+		}
+
+		return false, err
+	}
+
+	return now.Sub(info.ModTime()) >= dailyPullInterval, nil
+}
+
+// touchPullStamp records a successful pull.
+func touchPullStamp(stamp string, now time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(stamp), 0o700); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(stamp, nil, 0o600); err != nil {
+		return err
+	}
+
+	return os.Chtimes(stamp, now, now)
+}
+
+// pull fetches an image from its registry. Progress is written to standard
+// error.
+func (p *program) pull(ctx context.Context, cli *containerCli, ref string) error {
+	if err := cli.stream(ctx, p.stderr, "pull", ref); err != nil {
+		return fmt.Errorf("pulling image %s: %w", ref, err)
+	}
+
+	return nil
+}
+
+// pullImage makes an image available locally according to the pull policy.
+func (p *program) pullImage(ctx context.Context, cli *containerCli, ref string, now time.Time) error {
+	switch pullPolicy(p.pullPolicy) {
+	case pullAlways:
+		return p.pull(ctx, cli, ref)
+
+	case pullNever:
+		if !cli.imageExists(ctx, ref) {
+			return fmt.Errorf("image %s isn't available locally and pulling is disabled", ref)
+		}
+
+	case pullDaily:
+		stamp, err := pullStampPath(ref)
+		if err != nil {
+			return err
+		}
+
+		due, err := pullDue(stamp, now)
+		if err != nil {
+			return err
+		}
+
+		if due || !cli.imageExists(ctx, ref) {
+			if err := p.pull(ctx, cli, ref); err != nil {
+				return err
+			}
+
+			return touchPullStamp(stamp, now)
+		}
+
+	default:
+		if !cli.imageExists(ctx, ref) {
+			return p.pull(ctx, cli, ref)
+		}
+	}
+
+	return nil
+}
+
+// prepareImage builds the image from a Dockerfile if configured. Otherwise
+// the image is pinned to the digest recorded in the lock file, if any, and
+// pulled according to the pull policy.
+func (p *program) prepareImage(ctx context.Context) error {
+	if p.buildContext != "" || p.dockerfile != "" {
+		image, err := p.buildImage(ctx)
+		if err != nil {
+			return err
+		}
+
+		p.image = image
+
+		return nil
+	}
+
+	if containerEngine(p.containerEngine) == engineBwrap || p.image == "" {
+		return nil
+	}
+
+	lock, err := readLockFile(p.lockFilePath())
+	if err != nil {
+		return err
+	}
+
+	if digest := lock.Images[p.image]; digest != "" {
+		p.image = pinImage(p.image, digest)
+	}
+
+	if p.simulated() {
+		return nil
+	}
+
+	cli, err := p.containerCli()
+	if err != nil {
+		return err
+	}
+
+	return p.pullImage(ctx, cli, p.image, time.Now())
+}
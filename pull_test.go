@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/hansmi/cocoon/internal/testutil"
+)
+
+func TestPullDue(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	stamp := filepath.Join(t.TempDir(), "pull", "stamp")
+
+	if due, err := pullDue(stamp, now); err != nil {
+		t.Errorf("pullDue() failed: %v", err)
+	} else if !due {
+		t.Errorf("pullDue() returned false for missing stamp")
+	}
+
+	if err := touchPullStamp(stamp, now.Add(-time.Hour)); err != nil {
+		t.Errorf("touchPullStamp() failed: %v", err)
+	}
+
+	if due, err := pullDue(stamp, now); err != nil {
+		t.Errorf("pullDue() failed: %v", err)
+	} else if due {
+		t.Errorf("pullDue() returned true for recent pull")
+	}
+
+	if due, err := pullDue(stamp, now.Add(dailyPullInterval)); err != nil {
+		t.Errorf("pullDue() failed: %v", err)
+	} else if !due {
+		t.Errorf("pullDue() returned false for old pull")
+	}
+}
+
+func TestProgramPullImage(t *testing.T) {
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name    string
+		policy  pullPolicy
+		exists  bool
+		stamp   time.Time
+		want    []string
+		wantErr error
+	}{
+		{
+			name:   "always",
+			policy: pullAlways,
+			exists: true,
+			want:   []string{"pull alpine"},
+		},
+		{
+			name:   "missing",
+			policy: pullMissing,
+			want:   []string{"image inspect --format={{.Id}} alpine", "pull alpine"},
+		},
+		{
+			name:   "missing exists",
+			policy: pullMissing,
+			exists: true,
+			want:   []string{"image inspect --format={{.Id}} alpine"},
+		},
+		{
+			name:    "never",
+			policy:  pullNever,
+			want:    []string{"image inspect --format={{.Id}} alpine"},
+			wantErr: cmpopts.AnyError,
+		},
+		{
+			name:   "daily without stamp",
+			policy: pullDaily,
+			exists: true,
+			want:   []string{"pull alpine"},
+		},
+		{
+			name:   "daily recent",
+			policy: pullDaily,
+			exists: true,
+			stamp:  now.Add(-time.Hour),
+			want:   []string{"image inspect --format={{.Id}} alpine"},
+		},
+		{
+			name:   "daily recent missing",
+			policy: pullDaily,
+			stamp:  now.Add(-time.Hour),
+			want:   []string{"image inspect --format={{.Id}} alpine", "pull alpine"},
+		},
+		{
+			name:   "daily outdated",
+			policy: pullDaily,
+			exists: true,
+			stamp:  now.Add(-2 * dailyPullInterval),
+			want:   []string{"pull alpine"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+			tmpdir := t.TempDir()
+			log := filepath.Join(tmpdir, "log")
+
+			status := 1
+			if tc.exists {
+				status = 0
+			}
+
+			docker := testutil.MustWriteExecutable(t, filepath.Join(tmpdir, "docker"), `#!/bin/sh
+echo "$*" >> '`+log+`'
+if [ "$1" = image ]; then
+	exit `+strconv.Itoa(status)+`
+fi
+`)
+
+			if !tc.stamp.IsZero() {
+				stamp, err := pullStampPath("alpine")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if err := touchPullStamp(stamp, tc.stamp); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			p := newProgram()
+			p.stderr = &strings.Builder{}
+			p.pullPolicy = string(tc.policy)
+
+			err := p.pullImage(context.Background(), &containerCli{path: docker, engine: engineDocker}, "alpine", now)
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("pullImage() error diff (-want +got):\n%s", diff)
+			}
+
+			content, err := os.ReadFile(log)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tc.want, strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")); diff != "" {
+				t.Errorf("CLI invocation diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProgramPrepareImageLocked(t *testing.T) {
+	projectDir := t.TempDir()
+
+	if err := (&lockFile{
+		Images: map[string]string{"golang:1.22": testDigest},
+	}).write(filepath.Join(projectDir, lockFileName)); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		image string
+		want  string
+	}{
+		{image: "golang:1.22", want: "golang@" + testDigest},
+		{image: "golang:1.23", want: "golang:1.23"},
+	} {
+		t.Run(tc.image, func(t *testing.T) {
+			p := newProgram()
+			p.containerEngine = string(engineDocker)
+			p.projectDir = projectDir
+			p.image = tc.image
+			p.dryRun = true
+
+			if err := p.prepareImage(context.Background()); err != nil {
+				t.Errorf("prepareImage() failed: %v", err)
+			}
+
+			if diff := cmp.Diff(tc.want, p.image); diff != "" {
+				t.Errorf("Image diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// prepareRootfs returns a directory containing a root filesystem. Directories
+// are used as-is. Tar archives, e.g. written by "docker export", are extracted
+// into the user's cache directory once and reused afterwards.
+func prepareRootfs(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("root filesystem: %w", err)
+	}
+
+	if info.IsDir() {
+		return path, nil
+	}
+
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("root filesystem cache: %w", err)
+	}
+
+	return extractRootfsCached(path, info, filepath.Join(cacheDir, "cocoon", "rootfs"))
+}
+
+// rootfsCacheKey identifies an archive by its location, size and modification
+// time. Hashing the content would take too long for large archives.
+func rootfsCacheKey(path string, info fs.FileInfo) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%d\x00%d", path, info.Size(), info.ModTime().UnixNano())
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func extractRootfsCached(path string, info fs.FileInfo, cacheDir string) (_ string, err error) {
+	key, err := rootfsCacheKey(path, info)
+	if err != nil {
+		return "", err
+	}
+
+	dest := filepath.Join(cacheDir, key)
+
+	if ok, err := fileExists(dest); err != nil {
+		return "", err
+	} else if ok {
+		return dest, nil
+	}
+
+	if err := os.MkdirAll(cacheDir, 0o700); err != nil {
+		return "", err
+	}
+
+	tmpdir, err := os.MkdirTemp(cacheDir, "tmp-*")
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, os.RemoveAll(tmpdir))
+		}
+	}()
+
+	fh, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+
+	defer fh.Close()
+
+	if err := extractTar(fh, tmpdir); err != nil {
+		return "", fmt.Errorf("extracting %s: %w", path, err)
+	}
+
+	if err := os.Rename(tmpdir, dest); err != nil {
+		if ok, existsErr := fileExists(dest); existsErr == nil && ok {
+			// Extracted concurrently by another process.
+			return dest, os.RemoveAll(tmpdir)
+		}
+
+		return "", err
+	}
+
+	return dest, nil
+}
+
+// extractTar writes the content of an optionally gzip-compressed tar archive
+// to a directory. All filesystem operations are confined to the directory.
+// Device nodes and other special files are skipped as creating them requires
+// privileges.
+func extractTar(r io.Reader, dest string) error {
+	br := bufio.NewReader(r)
+
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+
+		defer zr.Close()
+
+		r = zr
+	} else {
+		r = br
+	}
+
+	root, err := os.OpenRoot(dest)
+	if err != nil {
+		return err
+	}
+
+	defer root.Close()
+
+	tr := tar.NewReader(r)
+
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		name := cleanArchivePath(hdr.Name)
+		if name == "" {
+			continue
+		}
+
+		if err := extractTarEntry(root, tr, hdr, name); err != nil {
+			return fmt.Errorf("%s: %w", hdr.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// cleanArchivePath converts an archive member name to a path relative to the
+// extraction directory.
+func cleanArchivePath(name string) string {
+	return strings.TrimPrefix(filepath.Clean("/"+name), "/")
+}
+
+func extractTarEntry(root *os.Root, r io.Reader, hdr *tar.Header, name string) error {
+	// Keep entries writable by the owner to allow removing the cache.
+	perm := hdr.FileInfo().Mode().Perm() | 0o200
+
+	if hdr.Typeflag != tar.TypeDir {
+		if err := root.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			return err
+		}
+
+		if err := root.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := root.MkdirAll(name, 0o700); err != nil {
+			return err
+		}
+
+		return root.Chmod(name, perm|0o700)
+
+	case tar.TypeReg:
+		fh, err := root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(fh, r); err != nil {
+			fh.Close()
+			return err
+		}
+
+		return fh.Close()
+
+	case tar.TypeSymlink:
+		return root.Symlink(hdr.Linkname, name)
+
+	case tar.TypeLink:
+		return root.Link(cleanArchivePath(hdr.Linkname), name)
+	}
+
+	return nil
+}
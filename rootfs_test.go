@@ -0,0 +1,174 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hansmi/cocoon/internal/testutil"
+)
+
+type tarEntry struct {
+	hdr     tar.Header
+	content string
+}
+
+func mustWriteTar(t *testing.T, w io.Writer, entries []tarEntry) {
+	t.Helper()
+
+	tw := tar.NewWriter(w)
+
+	for _, i := range entries {
+		hdr := i.hdr
+		hdr.Size = int64(len(i.content))
+
+		if hdr.Mode == 0 {
+			hdr.Mode = 0o644
+		}
+
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := io.WriteString(tw, i.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExtractTar(t *testing.T) {
+	entries := []tarEntry{
+		{hdr: tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0o755}},
+		{hdr: tar.Header{Name: "./etc/", Typeflag: tar.TypeDir, Mode: 0o755}},
+		{hdr: tar.Header{Name: "./etc/hostname", Typeflag: tar.TypeReg}, content: "container\n"},
+		{hdr: tar.Header{Name: "bin/sh", Typeflag: tar.TypeReg, Mode: 0o755}, content: "#!"},
+		{hdr: tar.Header{Name: "bin/bash", Typeflag: tar.TypeLink, Linkname: "bin/sh"}},
+		{hdr: tar.Header{Name: "etc/link", Typeflag: tar.TypeSymlink, Linkname: "hostname"}},
+		{hdr: tar.Header{Name: "dev/null", Typeflag: tar.TypeChar}},
+		{hdr: tar.Header{Name: "../outside", Typeflag: tar.TypeReg}, content: "inside"},
+	}
+
+	for _, compress := range []bool{false, true} {
+		t.Run(map[bool]string{false: "plain", true: "gzip"}[compress], func(t *testing.T) {
+			var buf bytes.Buffer
+
+			if compress {
+				zw := gzip.NewWriter(&buf)
+				mustWriteTar(t, zw, entries)
+				zw.Close()
+			} else {
+				mustWriteTar(t, &buf, entries)
+			}
+
+			parent := t.TempDir()
+			dest := filepath.Join(parent, "rootfs")
+
+			if err := os.Mkdir(dest, 0o700); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := extractTar(&buf, dest); err != nil {
+				t.Errorf("extractTar() failed: %v", err)
+			}
+
+			for path, want := range map[string]string{
+				"etc/hostname": "container\n",
+				"etc/link":     "container\n",
+				"bin/bash":     "#!",
+				"outside":      "inside",
+			} {
+				got, err := os.ReadFile(filepath.Join(dest, path))
+				if err != nil {
+					t.Errorf("ReadFile() failed: %v", err)
+				}
+
+				if diff := cmp.Diff(want, string(got)); diff != "" {
+					t.Errorf("%s content diff (-want +got):\n%s", path, diff)
+				}
+			}
+
+			if ok, err := fileExists(filepath.Join(parent, "outside")); err != nil || ok {
+				t.Errorf("File written outside of destination (exists=%t, err=%v)", ok, err)
+			}
+		})
+	}
+}
+
+func TestExtractTarSymlinkEscape(t *testing.T) {
+	var buf bytes.Buffer
+
+	outside := t.TempDir()
+
+	mustWriteTar(t, &buf, []tarEntry{
+		{hdr: tar.Header{Name: "escape", Typeflag: tar.TypeSymlink, Linkname: outside}},
+		{hdr: tar.Header{Name: "escape/file", Typeflag: tar.TypeReg}, content: "x"},
+	})
+
+	if err := extractTar(&buf, t.TempDir()); err == nil {
+		t.Errorf("extractTar() succeeded when writing via symlink")
+	}
+
+	if ok, err := fileExists(filepath.Join(outside, "file")); err != nil || ok {
+		t.Errorf("File written outside of destination (exists=%t, err=%v)", ok, err)
+	}
+}
+
+func TestPrepareRootfs(t *testing.T) {
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	dir := t.TempDir()
+
+	if got, err := prepareRootfs(dir); err != nil {
+		t.Errorf("prepareRootfs() failed: %v", err)
+	} else if got != dir {
+		t.Errorf("prepareRootfs(%q) returned %q, want same directory", dir, got)
+	}
+
+	archive := filepath.Join(t.TempDir(), "rootfs.tar")
+
+	fh, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mustWriteTar(t, fh, []tarEntry{
+		{hdr: tar.Header{Name: "hello", Typeflag: tar.TypeReg}, content: "world"},
+	})
+
+	if err := fh.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := prepareRootfs(archive)
+	if err != nil {
+		t.Errorf("prepareRootfs() failed: %v", err)
+	}
+
+	testutil.MustWriteFile(t, filepath.Join(first, "marker"), "")
+
+	second, err := prepareRootfs(archive)
+	if err != nil {
+		t.Errorf("prepareRootfs() failed: %v", err)
+	}
+
+	if first != second {
+		t.Errorf("Archive extracted again to %q, want %q", second, first)
+	}
+
+	if ok, err := fileExists(filepath.Join(second, "marker")); err != nil || !ok {
+		t.Errorf("Extracted directory not reused (exists=%t, err=%v)", ok, err)
+	}
+
+	if _, err := prepareRootfs(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("prepareRootfs() succeeded for missing path")
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Signals requesting termination which are relayed to the container.
+var terminationSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP}
+
+// notifyTermination starts capturing termination signals. Cocoon is no longer
+// terminated by them until the returned function is called, giving it a
+// chance to stop the container and to clean up.
+func notifyTermination() (<-chan os.Signal, func()) {
+	ch := make(chan os.Signal, len(terminationSignals))
+
+	signal.Notify(ch, terminationSignals...)
+
+	return ch, func() { signal.Stop(ch) }
+}
+
+// signalStatus returns the conventional exit status of a process terminated
+// by a signal.
+func signalStatus(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+
+	return 128
+}
+
+// signalTarget receives the signals relayed by superviseCommand.
+type signalTarget interface {
+	// signal forwards a signal.
+	signal(ctx context.Context, sig os.Signal) error
+
+	// stop terminates the target forcibly once the grace period has
+	// expired. It returns early when the context is cancelled.
+	stop(ctx context.Context, grace time.Duration) error
+}
+
+// processSignalTarget relays signals to the container runtime CLI directly,
+// e.g. for "exec". The CLI doesn't forward them to the command within the
+// container, i.e. the command keeps running after the CLI terminated.
+type processSignalTarget struct {
+	process *os.Process
+}
+
+func (t *processSignalTarget) signal(_ context.Context, sig os.Signal) error {
+	return t.process.Signal(sig)
+}
+
+func (t *processSignalTarget) stop(ctx context.Context, grace time.Duration) error {
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-timer.C:
+	}
+
+	return t.process.Kill()
+}
+
+// containerSignalTarget relays signals to a container using the runtime CLI.
+// Signals sent to cocoon don't reach the CLI running the container as it's
+// started in its own process group.
+type containerSignalTarget struct {
+	backend *dockerBackend
+	name    string
+}
+
+func (t *containerSignalTarget) signal(ctx context.Context, sig os.Signal) error {
+	value := sig.String()
+
+	if s, ok := sig.(syscall.Signal); ok {
+		value = strconv.Itoa(int(s))
+	}
+
+	_, err := t.backend.output(ctx, "kill", "--signal="+value, t.name)
+
+	return err
+}
+
+// stop has the runtime stop the container, killing it when it doesn't
+// terminate within the grace period. The runtime only supports whole seconds.
+func (t *containerSignalTarget) stop(ctx context.Context, grace time.Duration) error {
+	seconds := int(math.Ceil(grace.Seconds()))
+
+	_, err := t.backend.output(ctx, "stop", "--time="+strconv.Itoa(seconds), t.name)
+
+	return err
+}
+
+// newSignalTarget returns the target for signals relayed to a command run
+// using the runtime CLI. Signals reach named containers using the runtime.
+// Otherwise, e.g. for commands started using "exec", only the CLI process is
+// signalled.
+func newSignalTarget(backend containerBackend, process *os.Process, container string) signalTarget {
+	if b, ok := backend.(*dockerBackend); ok && container != "" {
+		return &containerSignalTarget{backend: b, name: container}
+	}
+
+	return &processSignalTarget{process: process}
+}
+
+// superviseCommand waits for a started command while relaying termination
+// signals to the target. After the first signal the target is stopped using
+// the grace period. A second signal stops it without further delay. The first
+// signal received is returned together with the result of waiting for the
+// command.
+func superviseCommand(ctx context.Context, cmd *exec.Cmd, signals <-chan os.Signal, target signalTarget, grace time.Duration) (os.Signal, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	var wg sync.WaitGroup
+
+	// Pending stop requests are abandoned once the command has finished.
+	defer wg.Wait()
+	defer cancel()
+
+	waitCh := make(chan error, 1)
+
+	go func() {
+		defer close(waitCh)
+
+		waitCh <- cmd.Wait()
+	}()
+
+	var received os.Signal
+	var stopped int
+
+	stopCh := make(chan error, 2)
+
+	stop := func(grace time.Duration) {
+		stopped++
+
+		wg.Go(func() {
+			stopCh <- target.stop(ctx, grace)
+		})
+	}
+
+	for {
+		select {
+		case err := <-waitCh:
+			return received, err
+
+		case sig := <-signals:
+			if received != nil {
+				if stopped < 2 {
+					stop(0)
+				}
+
+				continue
+			}
+
+			received = sig
+
+			if err := target.signal(ctx, sig); err != nil {
+				// The container may not have been created yet.
+				log.Printf("Forwarding signal %v failed, signalling runtime CLI: %v", sig, err)
+
+				cmd.Process.Signal(sig)
+			}
+
+			stop(grace)
+
+		case err := <-stopCh:
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Stopping container failed, killing runtime CLI: %v", err)
+
+				cmd.Process.Kill()
+			}
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestSignalStatus(t *testing.T) {
+	for _, tc := range []struct {
+		sig  os.Signal
+		want int
+	}{
+		{syscall.SIGHUP, 129},
+		{syscall.SIGINT, 130},
+		{syscall.SIGTERM, 143},
+	} {
+		if got := signalStatus(tc.sig); got != tc.want {
+			t.Errorf("signalStatus(%v) returned %d, want %d", tc.sig, got, tc.want)
+		}
+	}
+}
+
+func TestContainerSignalTarget(t *testing.T) {
+	var got [][]string
+
+	b := newDockerBackend("docker")
+	b.output = func(_ context.Context, args ...string) (string, error) {
+		got = append(got, args)
+		return "", nil
+	}
+
+	target := &containerSignalTarget{backend: b, name: "test"}
+
+	if err := target.signal(context.Background(), syscall.SIGTERM); err != nil {
+		t.Errorf("signal() failed: %v", err)
+	}
+
+	if err := target.stop(context.Background(), 1500*time.Millisecond); err != nil {
+		t.Errorf("stop() failed: %v", err)
+	}
+
+	if err := target.stop(context.Background(), 0); err != nil {
+		t.Errorf("stop() failed: %v", err)
+	}
+
+	if diff := cmp.Diff([][]string{
+		{"kill", "--signal=15", "test"},
+		{"stop", "--time=2", "test"},
+		{"stop", "--time=0", "test"},
+	}, got); diff != "" {
+		t.Errorf("CLI invocation diff (-want +got):\n%s", diff)
+	}
+}
+
+// fakeSignalTarget records calls and optionally terminates the supervised
+// process.
+type fakeSignalTarget struct {
+	mu      sync.Mutex
+	calls   []string
+	process *os.Process
+
+	// Whether the process terminates when signalled.
+	obey bool
+}
+
+func (t *fakeSignalTarget) record(call string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.calls = append(t.calls, call)
+}
+
+func (t *fakeSignalTarget) signal(_ context.Context, sig os.Signal) error {
+	t.record("signal " + sig.String())
+
+	if t.obey {
+		return t.process.Kill()
+	}
+
+	return nil
+}
+
+// stop mimics a runtime killing the process once the grace period has
+// expired.
+func (t *fakeSignalTarget) stop(ctx context.Context, grace time.Duration) error {
+	t.record("stop " + grace.String())
+
+	return (&processSignalTarget{process: t.process}).stop(ctx, grace)
+}
+
+func TestSuperviseCommand(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		signals   []os.Signal
+		obey      bool
+		grace     time.Duration
+		wantSig   os.Signal
+		wantCalls []string
+	}{
+		{
+			name:    "forwarded",
+			signals: []os.Signal{syscall.SIGTERM},
+			obey:    true,
+			grace:   time.Hour,
+			wantSig: syscall.SIGTERM,
+			wantCalls: []string{
+				"signal terminated",
+				"stop 1h0m0s",
+			},
+		},
+		{
+			name:    "grace period expired",
+			signals: []os.Signal{syscall.SIGINT},
+			grace:   10 * time.Millisecond,
+			wantSig: syscall.SIGINT,
+			wantCalls: []string{
+				"signal interrupt",
+				"stop 10ms",
+			},
+		},
+		{
+			name:    "repeated signal",
+			signals: []os.Signal{syscall.SIGHUP, syscall.SIGTERM},
+			grace:   time.Hour,
+			wantSig: syscall.SIGHUP,
+			wantCalls: []string{
+				"signal hangup",
+				"stop 1h0m0s",
+				"stop 0s",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			t.Cleanup(cancel)
+
+			cmd := exec.Command("sleep", "60")
+
+			if err := cmd.Start(); err != nil {
+				t.Fatal(err)
+			}
+
+			t.Cleanup(func() { cmd.Process.Kill() })
+
+			target := &fakeSignalTarget{
+				process: cmd.Process,
+				obey:    tc.obey,
+			}
+
+			signals := make(chan os.Signal, len(tc.signals))
+
+			for _, sig := range tc.signals {
+				signals <- sig
+			}
+
+			sig, err := superviseCommand(ctx, cmd, signals, target, tc.grace)
+
+			if err == nil {
+				t.Errorf("superviseCommand() succeeded for killed process")
+			}
+
+			if diff := cmp.Diff(tc.wantSig, sig); diff != "" {
+				t.Errorf("superviseCommand() signal diff (-want +got):\n%s", diff)
+			}
+
+			// Stop requests are handled concurrently.
+			if diff := cmp.Diff(tc.wantCalls, target.calls, cmpopts.SortSlices(func(a, b string) bool {
+				return a < b
+			})); diff != "" {
+				t.Errorf("Target calls diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSuperviseCommandWithoutSignal(t *testing.T) {
+	cmd := exec.Command("true")
+
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	target := &fakeSignalTarget{process: cmd.Process}
+
+	sig, err := superviseCommand(context.Background(), cmd, nil, target, time.Hour)
+
+	if sig != nil || err != nil {
+		t.Errorf("superviseCommand() returned signal %v and error %v", sig, err)
+	}
+
+	if len(target.calls) > 0 {
+		t.Errorf("Unexpected target calls: %q", target.calls)
+	}
+}
+
+func TestNewSignalTarget(t *testing.T) {
+	process := &os.Process{Pid: 123}
+	docker := newDockerBackend("docker")
+
+	for _, tc := range []struct {
+		name      string
+		backend   containerBackend
+		container string
+		want      signalTarget
+	}{
+		{
+			name:      "container",
+			backend:   docker,
+			container: "test",
+			want:      &containerSignalTarget{backend: docker, name: "test"},
+		},
+		{
+			// Commands started using "exec" aren't reached by signals
+			// relayed to the CLI.
+			name:    "exec",
+			backend: docker,
+			want:    &processSignalTarget{process: process},
+		},
+		{
+			name:      "bwrap",
+			backend:   newBwrapBackend("bwrap", ""),
+			container: "test",
+			want:      &processSignalTarget{process: process},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := newSignalTarget(tc.backend, process, tc.container)
+
+			if diff := cmp.Diff(tc.want, got, cmp.Comparer(func(a, b *dockerBackend) bool {
+				return a == b
+			}), cmp.Comparer(func(a, b *os.Process) bool {
+				return a == b
+			}), cmp.AllowUnexported(containerSignalTarget{}, processSignalTarget{})); diff != "" {
+				t.Errorf("newSignalTarget() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
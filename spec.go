@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+)
+
+const specFormatJSON = "json"
+
+var specFormatNames = []string{
+	specFormatJSON,
+}
+
+type specMountJSON struct {
+	Source      string      `json:"source"`
+	Destination string      `json:"destination"`
+	Mode        string      `json:"mode"`
+	Origin      mountOrigin `json:"origin"`
+}
+
+type specTmpfsJSON struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size,omitempty"`
+	Mode   string `json:"mode,omitempty"`
+	NoExec bool   `json:"noexec"`
+}
+
+type specVolumeJSON struct {
+	Name        string `json:"name"`
+	Destination string `json:"destination"`
+	Mode        string `json:"mode"`
+}
+
+type specEnvJSON struct {
+	Name  string  `json:"name"`
+	Value *string `json:"value"`
+
+	// Set for variables copied from the local environment.
+	PassThrough bool `json:"pass_through"`
+
+	// Either "base", "config", "literal" or the path of an environment file.
+	Source string `json:"source"`
+}
+
+// Machine-readable form of a run specification including the provenance of
+// mounts and environment variables.
+type specJSON struct {
+	Runtime       string           `json:"runtime"`
+	Image         string           `json:"image,omitempty"`
+	ContainerName string           `json:"container_name"`
+	User          string           `json:"user"`
+	Group         string           `json:"group"`
+	Groups        []string         `json:"groups,omitempty"`
+	Workdir       string           `json:"workdir"`
+	ReadOnly      bool             `json:"read_only"`
+	Mounts        []specMountJSON  `json:"mounts"`
+	Tmpfs         []specTmpfsJSON  `json:"tmpfs"`
+	Volumes       []specVolumeJSON `json:"volumes"`
+	Env           []specEnvJSON    `json:"env"`
+	TTY           bool             `json:"tty"`
+	Entrypoint    string           `json:"entrypoint"`
+	Args          []string         `json:"args"`
+}
+
+func newSpecJSON(runtime string, spec *runSpec, mounts *mountSet, sources envSources) *specJSON {
+	result := &specJSON{
+		Runtime:       runtime,
+		Image:         spec.image,
+		ContainerName: spec.name,
+		User:          spec.user,
+		Group:         spec.group,
+		Groups:        slices.Concat(spec.forwardedGroups, spec.groups),
+		Workdir:       spec.workdir,
+		ReadOnly:      spec.readOnly,
+		Mounts:        []specMountJSON{},
+		Tmpfs:         []specTmpfsJSON{},
+		Volumes:       []specVolumeJSON{},
+		Env:           []specEnvJSON{},
+		TTY:           spec.tty,
+		Entrypoint:    spec.entrypoint,
+		Args:          append([]string{}, spec.args...),
+	}
+
+	for _, i := range spec.mounts {
+		result.Mounts = append(result.Mounts, specMountJSON{
+			Source:      i.src,
+			Destination: i.dst,
+			Mode:        i.mode.String(),
+			Origin:      mounts.origin(i.dst),
+		})
+	}
+
+	for _, i := range spec.tmpfs {
+		entry := specTmpfsJSON{
+			Path:   i.path,
+			Size:   i.size,
+			NoExec: i.noexec,
+		}
+
+		if i.mode != 0 {
+			entry.Mode = fmt.Sprintf("%04o", i.mode)
+		}
+
+		result.Tmpfs = append(result.Tmpfs, entry)
+	}
+
+	for _, i := range spec.volumes {
+		result.Volumes = append(result.Volumes, specVolumeJSON{
+			Name:        i.name,
+			Destination: i.dst,
+			Mode:        i.mode.String(),
+		})
+	}
+
+	for _, variable := range slices.Sorted(maps.Keys(spec.env)) {
+		value := spec.env[variable]
+
+		result.Env = append(result.Env, specEnvJSON{
+			Name:        variable,
+			Value:       value,
+			PassThrough: value == nil,
+			Source:      sources[variable],
+		})
+	}
+
+	return result
+}
+
+// printSpec writes the run specification in the requested format.
+func (p *program) printSpec(w io.Writer, spec *runSpec, mounts *mountSet, sources envSources) error {
+	runtime := p.containerEngine
+
+	if containerEngine(runtime) == engineAuto {
+		cli, err := p.containerCli()
+		if err != nil {
+			return err
+		}
+
+		runtime = string(cli.engine)
+	}
+
+	switch p.printSpecFormat {
+	case specFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(newSpecJSON(runtime, spec, mounts, sources))
+	}
+
+	return fmt.Errorf("unsupported specification format %q", p.printSpecFormat)
+}
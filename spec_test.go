@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hansmi/cocoon/internal/ref"
+	"github.com/hansmi/cocoon/internal/testutil"
+)
+
+func TestNewSpecJSON(t *testing.T) {
+	mounts := newMountSet()
+	mounts.set("/etc/hosts", mountReadOnly, mountOriginDefault)
+	mounts.bind("/srv/cache", "/cache", mountReadWrite, mountOriginFlag)
+	mounts.set("/run/agent.sock", mountReadOnly, mountOriginSSHAgent)
+
+	spec := &runSpec{
+		name:     "name",
+		image:    "image",
+		user:     "1000",
+		group:    "100",
+		workdir:  "/work",
+		readOnly: true,
+		mounts:   mounts.list(),
+		tmpfs: []tmpfsMount{
+			{path: "/tmp"},
+			{path: "/var/tmp", size: 1 << 20, mode: 0o1777, noexec: true},
+		},
+		volumes: []volumeMount{
+			{name: "gomod", dst: "/go/pkg/mod", mode: mountReadWrite},
+		},
+		env: envMap{
+			"FOO":  ref.Ref("bar"),
+			"HOME": nil,
+		},
+		entrypoint: "make",
+		args:       []string{"test"},
+	}
+
+	got := newSpecJSON("docker", spec, mounts, envSources{
+		"FOO":  "/project/env.yaml",
+		"HOME": envSourceBase,
+	})
+
+	if diff := cmp.Diff(&specJSON{
+		Runtime:       "docker",
+		Image:         "image",
+		ContainerName: "name",
+		User:          "1000",
+		Group:         "100",
+		Workdir:       "/work",
+		ReadOnly:      true,
+		Mounts: []specMountJSON{
+			{Source: "/srv/cache", Destination: "/cache", Mode: "rw", Origin: mountOriginFlag},
+			{Source: "/etc/hosts", Destination: "/etc/hosts", Mode: "ro", Origin: mountOriginDefault},
+			{Source: "/run/agent.sock", Destination: "/run/agent.sock", Mode: "ro", Origin: mountOriginSSHAgent},
+		},
+		Tmpfs: []specTmpfsJSON{
+			{Path: "/tmp"},
+			{Path: "/var/tmp", Size: 1 << 20, Mode: "1777", NoExec: true},
+		},
+		Volumes: []specVolumeJSON{
+			{Name: "gomod", Destination: "/go/pkg/mod", Mode: "rw"},
+		},
+		Env: []specEnvJSON{
+			{Name: "FOO", Value: ref.Ref("bar"), Source: "/project/env.yaml"},
+			{Name: "HOME", PassThrough: true, Source: envSourceBase},
+		},
+		Entrypoint: "make",
+		Args:       []string{"test"},
+	}, got); diff != "" {
+		t.Errorf("newSpecJSON() diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestProgramRunPrintSpec(t *testing.T) {
+	tmpdir := t.TempDir()
+	marker := filepath.Join(tmpdir, "invoked")
+
+	podman := testutil.MustWriteExecutable(t, filepath.Join(tmpdir, "podman"), "#!/bin/sh\ntouch '"+marker+"'\nexit 1\n")
+
+	t.Setenv(dbusSessionBusAddressEnv, "unix:path=/run/user/1000/bus")
+
+	var stdout strings.Builder
+
+	p := newProgram()
+	p.stdout = &stdout
+	p.containerEngine = string(engineAuto)
+	p.dockerCliProgram = podman
+	p.xdgDBusProxyProgram = filepath.Join(tmpdir, "missing")
+	p.image = "image"
+	p.workdir = "/work"
+	p.forwardDBus = true
+	p.env = []string{"FOO=bar"}
+	p.printSpecFormat = specFormatJSON
+
+	p.mounts.set("/work", mountReadWrite, mountOriginDefault)
+	p.volumes.set(volumeMount{name: "cache", dst: "/cache", mode: mountReadWrite})
+
+	if err := p.run(context.Background()); err != nil {
+		t.Errorf("run() failed: %v", err)
+	}
+
+	if ok, err := fileExists(marker); err != nil {
+		t.Error(err)
+	} else if ok {
+		t.Errorf("Container runtime CLI was invoked")
+	}
+
+	var got specJSON
+
+	if err := json.Unmarshal([]byte(stdout.String()), &got); err != nil {
+		t.Fatalf("Decoding specification failed: %v", err)
+	}
+
+	if got.Runtime != string(enginePodman) {
+		t.Errorf("Runtime is %q, want %q", got.Runtime, enginePodman)
+	}
+
+	origins := map[string]mountOrigin{}
+	dbusSocket := ""
+
+	for _, i := range got.Mounts {
+		origins[filepath.Base(i.Destination)] = i.Origin
+
+		if i.Origin == mountOriginDBus {
+			dbusSocket = i.Destination
+		}
+	}
+
+	if diff := cmp.Diff(map[string]mountOrigin{
+		"work":   mountOriginDefault,
+		"socket": mountOriginDBus,
+	}, origins); diff != "" {
+		t.Errorf("Mount origin diff (-want +got):\n%s", diff)
+	}
+
+	sources := map[string]string{}
+
+	for _, i := range got.Env {
+		sources[i.Name] = i.Source
+
+		if i.Name == dbusSessionBusAddressEnv {
+			if want := "unix:path=" + dbusSocket; i.Value == nil || *i.Value != want {
+				t.Errorf("%s is %v, want %q", dbusSessionBusAddressEnv, i.Value, want)
+			}
+		}
+	}
+
+	if diff := cmp.Diff(map[string]string{
+		"HOME":                   envSourceBase,
+		dbusSessionBusAddressEnv: envSourceBase,
+		"FOO":                    envSourceLiteral,
+	}, sources); diff != "" {
+		t.Errorf("Environment source diff (-want +got):\n%s", diff)
+	}
+}
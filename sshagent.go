@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const sshAuthSockEnv = "SSH_AUTH_SOCK"
+
+var (
+	errSSHAgentRefused       = errors.New("operation not permitted by cocoon")
+	errSSHAgentKeyNotAllowed = errors.New("key not exposed by cocoon")
+)
+
+// sshAgentKeyMatches reports whether a key matches one of the patterns. A
+// pattern is either a fingerprint in the form "SHA256:..." or "MD5:...", or a
+// shell pattern for the key comment as used by path.Match. All keys match
+// when no patterns are given.
+func sshAgentKeyMatches(patterns []string, key *agent.Key) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	var fingerprints []string
+
+	if pub, err := ssh.ParsePublicKey(key.Blob); err == nil {
+		fingerprints = append(fingerprints,
+			ssh.FingerprintSHA256(pub),
+			"MD5:"+ssh.FingerprintLegacyMD5(pub),
+		)
+	}
+
+	for _, pattern := range patterns {
+		for _, i := range fingerprints {
+			if pattern == i {
+				return true
+			}
+		}
+
+		if ok, err := path.Match(pattern, key.Comment); err == nil && ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// confirmSSHAgentKey asks the user for permission to use a key. Like
+// ssh-agent for keys added with "ssh-add -c" the program named by the
+// SSH_ASKPASS environment variable is used.
+func confirmSSHAgentKey(key *agent.Key) error {
+	program := os.Getenv("SSH_ASKPASS")
+	if program == "" {
+		program = "ssh-askpass"
+	}
+
+	fingerprint := "unknown"
+
+	if pub, err := ssh.ParsePublicKey(key.Blob); err == nil {
+		fingerprint = ssh.FingerprintSHA256(pub)
+	}
+
+	cmd := exec.Command(program, fmt.Sprintf("Allow use of key %s from container?\nKey fingerprint %s.", key.Comment, fingerprint))
+	cmd.Env = append(os.Environ(), "SSH_ASKPASS_PROMPT=confirm")
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("use of key %s not confirmed: %w", fingerprint, err)
+	}
+
+	return nil
+}
+
+// filteringSSHAgent exposes a subset of the keys held by another agent.
+// Requests modifying the agent are refused.
+type filteringSSHAgent struct {
+	upstream agent.ExtendedAgent
+	patterns []string
+
+	// Called before every signature if set.
+	confirm func(*agent.Key) error
+}
+
+var _ agent.ExtendedAgent = (*filteringSSHAgent)(nil)
+
+func (a *filteringSSHAgent) List() ([]*agent.Key, error) {
+	keys, err := a.upstream.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*agent.Key
+
+	for _, i := range keys {
+		if sshAgentKeyMatches(a.patterns, i) {
+			result = append(result, i)
+		}
+	}
+
+	return result, nil
+}
+
+// checkKey returns an error unless the key is exposed and its use has been
+// confirmed.
+func (a *filteringSSHAgent) checkKey(key ssh.PublicKey) error {
+	keys, err := a.List()
+	if err != nil {
+		return err
+	}
+
+	blob := key.Marshal()
+
+	for _, i := range keys {
+		if !bytes.Equal(i.Blob, blob) {
+			continue
+		}
+
+		if a.confirm != nil {
+			return a.confirm(i)
+		}
+
+		return nil
+	}
+
+	return errSSHAgentKeyNotAllowed
+}
+
+func (a *filteringSSHAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return a.SignWithFlags(key, data, 0)
+}
+
+func (a *filteringSSHAgent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	if err := a.checkKey(key); err != nil {
+		return nil, err
+	}
+
+	return a.upstream.SignWithFlags(key, data, flags)
+}
+
+func (*filteringSSHAgent) Add(agent.AddedKey) error {
+	return errSSHAgentRefused
+}
+
+func (*filteringSSHAgent) Remove(ssh.PublicKey) error {
+	return errSSHAgentRefused
+}
+
+func (*filteringSSHAgent) RemoveAll() error {
+	return errSSHAgentRefused
+}
+
+func (*filteringSSHAgent) Lock([]byte) error {
+	return errSSHAgentRefused
+}
+
+func (*filteringSSHAgent) Unlock([]byte) error {
+	return errSSHAgentRefused
+}
+
+func (*filteringSSHAgent) Signers() ([]ssh.Signer, error) {
+	return nil, errSSHAgentRefused
+}
+
+func (*filteringSSHAgent) Extension(string, []byte) ([]byte, error) {
+	return nil, agent.ErrExtensionUnsupported
+}
+
+// sshAgentProxy serves a filtering agent on a socket. Every connection is
+// forwarded to its own connection to the upstream agent.
+type sshAgentProxy struct {
+	upstream string
+	patterns []string
+	confirm  func(*agent.Key) error
+
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func (p *sshAgentProxy) track(conn net.Conn, add bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.conns == nil {
+		p.conns = map[net.Conn]struct{}{}
+	}
+
+	if add {
+		p.conns[conn] = struct{}{}
+	} else {
+		delete(p.conns, conn)
+	}
+}
+
+func (p *sshAgentProxy) handle(conn net.Conn) error {
+	upstream, err := net.Dial("unix", p.upstream)
+	if err != nil {
+		return err
+	}
+
+	defer upstream.Close()
+
+	err = agent.ServeAgent(&filteringSSHAgent{
+		upstream: agent.NewClient(upstream),
+		patterns: p.patterns,
+		confirm:  p.confirm,
+	}, conn)
+
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+
+	return err
+}
+
+// serve accepts connections until the listener is closed.
+func (p *sshAgentProxy) serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			return err
+		}
+
+		p.track(conn, true)
+		p.wg.Add(1)
+
+		go func() {
+			defer p.wg.Done()
+			defer p.track(conn, false)
+			defer conn.Close()
+
+			if err := p.handle(conn); err != nil {
+				log.Printf("SSH agent proxy: %v", err)
+			}
+		}()
+	}
+}
+
+// close terminates all connections and waits for their handlers to return.
+func (p *sshAgentProxy) close() {
+	p.mu.Lock()
+
+	for conn := range p.conns {
+		conn.Close()
+	}
+
+	p.mu.Unlock()
+
+	p.wg.Wait()
+}
+
+// sshAgentFiltered reports whether the SSH agent is forwarded through a
+// filtering proxy instead of mounting its socket directly.
+func (p *program) sshAgentFiltered() bool {
+	return len(p.sshAgentKeys) > 0 || p.sshAgentConfirm
+}
+
+// startSSHAgentProxy serves a filtering proxy for the agent listening on the
+// upstream socket. The proxy's socket is created in the runtime's base
+// directory.
+func (p *program) startSSHAgentProxy(r *runtime, upstream string) (string, func() error, error) {
+	sockDir, err := r.createDir("ssh-agent")
+	if err != nil {
+		return "", nil, err
+	}
+
+	sock := filepath.Join(sockDir, "socket")
+
+	if p.simulated() {
+		// Only the socket location is needed.
+		return sock, func() error { return nil }, nil
+	}
+
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		return "", nil, err
+	}
+
+	proxy := &sshAgentProxy{
+		upstream: upstream,
+		patterns: p.sshAgentKeys,
+	}
+
+	if p.sshAgentConfirm {
+		proxy.confirm = confirmSSHAgentKey
+	}
+
+	serveErrCh := make(chan error, 1)
+
+	go func() {
+		defer close(serveErrCh)
+
+		serveErrCh <- proxy.serve(l)
+	}()
+
+	return sock, func() error {
+		err := l.Close()
+
+		err = errors.Join(err, <-serveErrCh)
+
+		proxy.close()
+
+		return err
+	}, nil
+}
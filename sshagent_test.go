@@ -0,0 +1,252 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+type testSSHKey struct {
+	comment string
+	signer  ssh.Signer
+	private ed25519.PrivateKey
+}
+
+func newTestSSHKey(t *testing.T, comment string) testSSHKey {
+	t.Helper()
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testSSHKey{comment: comment, signer: signer, private: private}
+}
+
+func (k testSSHKey) agentKey() *agent.Key {
+	return &agent.Key{
+		Format:  k.signer.PublicKey().Type(),
+		Blob:    k.signer.PublicKey().Marshal(),
+		Comment: k.comment,
+	}
+}
+
+// startTestSSHAgent serves an in-memory agent holding the given keys.
+func startTestSSHAgent(t *testing.T, keys ...testSSHKey) (string, agent.Agent) {
+	t.Helper()
+
+	keyring := agent.NewKeyring()
+
+	for _, i := range keys {
+		if err := keyring.Add(agent.AddedKey{PrivateKey: i.private, Comment: i.comment}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sock := filepath.Join(t.TempDir(), "agent")
+
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+
+	return sock, keyring
+}
+
+func dialTestSSHAgent(t *testing.T, sock string) agent.ExtendedAgent {
+	t.Helper()
+
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	return agent.NewClient(conn)
+}
+
+func TestSSHAgentKeyMatches(t *testing.T) {
+	key := newTestSSHKey(t, "user@work")
+	agentKey := key.agentKey()
+
+	for _, tc := range []struct {
+		name     string
+		patterns []string
+		want     bool
+	}{
+		{name: "no patterns", want: true},
+		{name: "comment", patterns: []string{"user@work"}, want: true},
+		{name: "comment pattern", patterns: []string{"other", "*@work"}, want: true},
+		{name: "sha256", patterns: []string{ssh.FingerprintSHA256(key.signer.PublicKey())}, want: true},
+		{name: "md5", patterns: []string{"MD5:" + ssh.FingerprintLegacyMD5(key.signer.PublicKey())}, want: true},
+		{name: "no match", patterns: []string{"user@home", "SHA256:xyz"}},
+		{name: "invalid pattern", patterns: []string{"["}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := sshAgentKeyMatches(tc.patterns, agentKey); got != tc.want {
+				t.Errorf("sshAgentKeyMatches(%q) = %t, want %t", tc.patterns, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSSHAgentProxy(t *testing.T) {
+	work := newTestSSHKey(t, "user@work")
+	home := newTestSSHKey(t, "user@home")
+
+	upstream, keyring := startTestSSHAgent(t, work, home)
+
+	var confirmed []string
+	var denyConfirmation bool
+
+	proxy := &sshAgentProxy{
+		upstream: upstream,
+		patterns: []string{"*@work"},
+		confirm: func(key *agent.Key) error {
+			confirmed = append(confirmed, key.Comment)
+
+			if denyConfirmation {
+				return errors.New("denied")
+			}
+
+			return nil
+		},
+	}
+
+	sock := filepath.Join(t.TempDir(), "proxy")
+
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serveErrCh := make(chan error, 1)
+
+	go func() {
+		serveErrCh <- proxy.serve(l)
+	}()
+
+	client := dialTestSSHAgent(t, sock)
+
+	keys, err := client.List()
+	if err != nil {
+		t.Errorf("List() failed: %v", err)
+	}
+
+	if diff := cmp.Diff([]*agent.Key{work.agentKey()}, keys); diff != "" {
+		t.Errorf("List() diff (-want +got):\n%s", diff)
+	}
+
+	data := []byte("data")
+
+	if sig, err := client.Sign(work.signer.PublicKey(), data); err != nil {
+		t.Errorf("Sign() failed: %v", err)
+	} else if err := work.signer.PublicKey().Verify(data, sig); err != nil {
+		t.Errorf("Verify() failed: %v", err)
+	}
+
+	if _, err := client.Sign(home.signer.PublicKey(), data); err == nil {
+		t.Errorf("Sign() with hidden key succeeded")
+	}
+
+	denyConfirmation = true
+
+	if _, err := client.Sign(work.signer.PublicKey(), data); err == nil {
+		t.Errorf("Sign() without confirmation succeeded")
+	}
+
+	if diff := cmp.Diff([]string{"user@work", "user@work"}, confirmed); diff != "" {
+		t.Errorf("Confirmation diff (-want +got):\n%s", diff)
+	}
+
+	for name, fn := range map[string]func() error{
+		"Add": func() error {
+			return client.Add(agent.AddedKey{PrivateKey: newTestSSHKey(t, "new").private})
+		},
+		"Remove":    func() error { return client.Remove(work.signer.PublicKey()) },
+		"RemoveAll": client.RemoveAll,
+		"Lock":      func() error { return client.Lock([]byte("secret")) },
+		"Unlock":    func() error { return client.Unlock([]byte("secret")) },
+	} {
+		if err := fn(); err == nil {
+			t.Errorf("%s() succeeded", name)
+		}
+	}
+
+	if keys, err := keyring.List(); err != nil {
+		t.Errorf("List() failed: %v", err)
+	} else if len(keys) != 2 {
+		t.Errorf("Upstream agent was modified: %v", keys)
+	}
+
+	if err := l.Close(); err != nil {
+		t.Errorf("Close() failed: %v", err)
+	}
+
+	if err := <-serveErrCh; err != nil {
+		t.Errorf("serve() failed: %v", err)
+	}
+
+	proxy.close()
+}
+
+func TestProgramStartSSHAgentProxy(t *testing.T) {
+	work := newTestSSHKey(t, "user@work")
+	home := newTestSSHKey(t, "user@home")
+
+	upstream, _ := startTestSSHAgent(t, work, home)
+
+	p := newProgram()
+	p.sshAgentKeys = []string{ssh.FingerprintSHA256(home.signer.PublicKey())}
+
+	r := &runtime{}
+	t.Cleanup(func() { os.RemoveAll(r.baseDir) })
+
+	sock, cleanup, err := p.startSSHAgentProxy(r, upstream)
+	if err != nil {
+		t.Fatalf("startSSHAgentProxy() failed: %v", err)
+	}
+
+	keys, err := dialTestSSHAgent(t, sock).List()
+	if err != nil {
+		t.Errorf("List() failed: %v", err)
+	}
+
+	if diff := cmp.Diff([]*agent.Key{home.agentKey()}, keys); diff != "" {
+		t.Errorf("List() diff (-want +got):\n%s", diff)
+	}
+
+	if err := cleanup(); err != nil {
+		t.Errorf("Cleanup failed: %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+const defaultTmpfsPath = "/tmp"
+
+var errTmpfsSpecInvalid = errors.New("invalid tmpfs specification")
+
+// Mount of a temporary filesystem.
+type tmpfsMount struct {
+	path string
+
+	// Maximum size in bytes. Zero uses the runtime's default.
+	size int64
+
+	// Permission bits of the root directory. Zero uses the runtime's
+	// default.
+	mode uint32
+
+	noexec bool
+}
+
+// parseByteSize parses a size with an optional binary unit suffix ("k", "m"
+// or "g").
+func parseByteSize(value string) (int64, error) {
+	multiplier := int64(1)
+
+	if n := len(value); n > 0 {
+		switch strings.ToLower(value[n-1:]) {
+		case "k":
+			multiplier = 1 << 10
+		case "m":
+			multiplier = 1 << 20
+		case "g":
+			multiplier = 1 << 30
+		}
+
+		if multiplier != 1 {
+			value = value[:n-1]
+		}
+	}
+
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if size <= 0 {
+		return 0, errors.New("size must be positive")
+	}
+
+	return size * multiplier, nil
+}
+
+// parseTmpfsSpec parses a tmpfs specification in the form
+// "PATH[:OPTION,...]". Supported options are "size=SIZE", "mode=OCTAL",
+// "exec" and "noexec".
+func parseTmpfsSpec(value string) (tmpfsMount, error) {
+	path, options, _ := strings.Cut(value, ":")
+
+	if !filepath.IsAbs(path) {
+		return tmpfsMount{}, fmt.Errorf("%w: path must be absolute: %q", errTmpfsSpecInvalid, value)
+	}
+
+	result := tmpfsMount{
+		path: filepath.Clean(path),
+	}
+
+	for option := range strings.SplitSeq(options, ",") {
+		name, arg, _ := strings.Cut(option, "=")
+
+		var err error
+
+		switch name {
+		case "":
+		case "exec":
+			result.noexec = false
+		case "noexec":
+			result.noexec = true
+		case "size":
+			result.size, err = parseByteSize(arg)
+		case "mode":
+			var mode uint64
+
+			if mode, err = strconv.ParseUint(arg, 8, 32); err == nil && mode > 0o7777 {
+				err = errors.New("mode out of range")
+			}
+
+			result.mode = uint32(mode)
+
+		default:
+			err = errors.New("unknown option")
+		}
+
+		if err != nil {
+			return tmpfsMount{}, fmt.Errorf("%w: option %q: %v", errTmpfsSpecInvalid, option, err)
+		}
+	}
+
+	return result, nil
+}
+
+// Set of temporary filesystems keyed by their path within the container.
+type tmpfsSet struct {
+	entries map[string]tmpfsMount
+}
+
+var _ fmt.Stringer = (*tmpfsSet)(nil)
+
+func newTmpfsSet() *tmpfsSet {
+	return &tmpfsSet{
+		entries: map[string]tmpfsMount{},
+	}
+}
+
+func (s *tmpfsSet) String() string {
+	return fmt.Sprint(s.entries)
+}
+
+func (s *tmpfsSet) clone() *tmpfsSet {
+	result := newTmpfsSet()
+	maps.Copy(result.entries, s.entries)
+	return result
+}
+
+// set adds a temporary filesystem. An existing entry at the same path is
+// replaced.
+func (s *tmpfsSet) set(m tmpfsMount) {
+	s.entries[m.path] = m
+}
+
+func (s *tmpfsSet) has(path string) bool {
+	_, ok := s.entries[path]
+	return ok
+}
+
+// list returns all temporary filesystems with parent directories ordered
+// before their children.
+func (s *tmpfsSet) list() []tmpfsMount {
+	var result []tmpfsMount
+
+	for _, path := range slices.SortedFunc(maps.Keys(s.entries), comparePaths) {
+		result = append(result, s.entries[path])
+	}
+
+	return result
+}
+
+// checkShadowing returns an error if a temporary filesystem, a bind mount
+// or a volume are mounted at the same location. One of them would hide the
+// other.
+func (s *tmpfsSet) checkShadowing(mounts *mountSet, volumes *volumeSet) error {
+	var err error
+
+	for _, i := range mounts.list() {
+		if s.has(i.dst) {
+			err = errors.Join(err, fmt.Errorf("tmpfs at %s shadows bind mount of %s", i.dst, i.src))
+		}
+
+		if volumes.has(i.dst) {
+			err = errors.Join(err, fmt.Errorf("volume at %s shadows bind mount of %s", i.dst, i.src))
+		}
+	}
+
+	for _, i := range volumes.list() {
+		if s.has(i.dst) {
+			err = errors.Join(err, fmt.Errorf("tmpfs at %s shadows volume %s", i.dst, i.name))
+		}
+	}
+
+	return err
+}
+
+type tmpfsSetFlag struct {
+	s *tmpfsSet
+}
+
+var _ kingpin.Value = (*tmpfsSetFlag)(nil)
+
+func (f *tmpfsSetFlag) String() string {
+	return f.s.String()
+}
+
+func (*tmpfsSetFlag) IsCumulative() bool {
+	return true
+}
+
+func (f *tmpfsSetFlag) Set(value string) error {
+	m, err := parseTmpfsSpec(value)
+	if err != nil {
+		return err
+	}
+
+	f.s.set(m)
+
+	return nil
+}
+
+func tmpfsSetVar(s kingpin.Settings, target *tmpfsSet) {
+	s.SetValue(&tmpfsSetFlag{
+		s: target,
+	})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestParseByteSize(t *testing.T) {
+	for _, tc := range []struct {
+		value   string
+		want    int64
+		wantErr error
+	}{
+		{value: "1", want: 1},
+		{value: "4096", want: 4096},
+		{value: "64k", want: 64 << 10},
+		{value: "16M", want: 16 << 20},
+		{value: "2g", want: 2 << 30},
+		{value: "", wantErr: cmpopts.AnyError},
+		{value: "k", wantErr: cmpopts.AnyError},
+		{value: "0", wantErr: cmpopts.AnyError},
+		{value: "-1m", wantErr: cmpopts.AnyError},
+		{value: "1t", wantErr: cmpopts.AnyError},
+	} {
+		t.Run(tc.value, func(t *testing.T) {
+			got, err := parseByteSize(tc.value)
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("parseByteSize() error diff (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("parseByteSize() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseTmpfsSpec(t *testing.T) {
+	for _, tc := range []struct {
+		value   string
+		want    tmpfsMount
+		wantErr error
+	}{
+		{value: "/run", want: tmpfsMount{path: "/run"}},
+		{value: "/var/tmp/", want: tmpfsMount{path: "/var/tmp"}},
+		{
+			value: "/cache:size=512m,mode=1777,noexec",
+			want: tmpfsMount{
+				path:   "/cache",
+				size:   512 << 20,
+				mode:   0o1777,
+				noexec: true,
+			},
+		},
+		{
+			value: "/x:noexec,exec,mode=700",
+			want:  tmpfsMount{path: "/x", mode: 0o700},
+		},
+		{value: "relative", wantErr: errTmpfsSpecInvalid},
+		{value: "/x:size=abc", wantErr: errTmpfsSpecInvalid},
+		{value: "/x:mode=9", wantErr: errTmpfsSpecInvalid},
+		{value: "/x:mode=17777", wantErr: errTmpfsSpecInvalid},
+		{value: "/x:unknown", wantErr: errTmpfsSpecInvalid},
+	} {
+		t.Run(tc.value, func(t *testing.T) {
+			got, err := parseTmpfsSpec(tc.value)
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("parseTmpfsSpec() error diff (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.want, got, cmp.AllowUnexported(tmpfsMount{})); diff != "" {
+				t.Errorf("parseTmpfsSpec() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTmpfsSet(t *testing.T) {
+	s := newTmpfsSet()
+
+	app := kingpin.New(t.Name(), "")
+
+	tmpfsSetVar(app.Flag("tmpfs", ""), s)
+
+	if _, err := app.Parse([]string{
+		"--tmpfs=/var/tmp/cache",
+		"--tmpfs=/var/tmp:size=1m",
+		"--tmpfs=/run",
+		"--tmpfs=/var/tmp:size=2m",
+	}); err != nil {
+		t.Errorf("Parsing flags failed: %v", err)
+	}
+
+	if diff := cmp.Diff([]tmpfsMount{
+		{path: "/run"},
+		{path: "/var/tmp", size: 2 << 20},
+		{path: "/var/tmp/cache"},
+	}, s.list(), cmp.AllowUnexported(tmpfsMount{})); diff != "" {
+		t.Errorf("Tmpfs list diff (-want +got):\n%s", diff)
+	}
+
+	mounts := newMountSet()
+	mounts.set("/run/user", mountReadOnly, mountOriginDefault)
+
+	volumes := newVolumeSet()
+	volumes.set(volumeMount{name: "gomod", dst: "/go/pkg/mod"})
+
+	if err := s.checkShadowing(mounts, volumes); err != nil {
+		t.Errorf("checkShadowing() failed: %v", err)
+	}
+
+	mounts.bind("/srv/cache", "/var/tmp/cache", mountReadWrite, mountOriginFlag)
+
+	if err := s.checkShadowing(mounts, volumes); err == nil {
+		t.Errorf("checkShadowing() didn't detect shadowed mount")
+	}
+
+	for _, tc := range []struct {
+		name   string
+		volume volumeMount
+	}{
+		{name: "bind mount", volume: volumeMount{name: "user", dst: "/run/user"}},
+		{name: "tmpfs", volume: volumeMount{name: "run", dst: "/run"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mounts := newMountSet()
+			mounts.set("/run/user", mountReadOnly, mountOriginDefault)
+
+			volumes := newVolumeSet()
+			volumes.set(tc.volume)
+
+			if err := s.checkShadowing(mounts, volumes); err == nil {
+				t.Errorf("checkShadowing() didn't detect shadowed volume")
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// isNumericID reports whether a user or group is given by its ID.
+func isNumericID(value string) bool {
+	_, err := strconv.ParseUint(value, 10, 32)
+	return err == nil
+}
+
+// lookupLocalUser returns the local account with the given name or ID. Nil
+// is returned for unknown users.
+func lookupLocalUser(value string) (*user.User, error) {
+	var u *user.User
+	var err error
+
+	if isNumericID(value) {
+		u, err = user.LookupId(value)
+	} else {
+		u, err = user.Lookup(value)
+	}
+
+	var unknownID user.UnknownUserIdError
+	var unknownName user.UnknownUserError
+
+	if errors.As(err, &unknownID) || errors.As(err, &unknownName) {
+		return nil, nil
+	}
+
+	return u, err
+}
+
+// lookupLocalGroup returns the ID of the local group with the given name.
+// An empty string is returned for unknown groups.
+func lookupLocalGroup(name string) (string, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		var unknown user.UnknownGroupError
+
+		if errors.As(err, &unknown) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	return g.Gid, nil
+}
+
+// resolveUser replaces user and group names known locally with their IDs,
+// giving them the same ID within the container. The primary group follows
+// the user unless given explicitly. The default mounts of the home directory
+// are replaced with those of the chosen user. Names unknown locally are kept
+// for resolution within the image, see checkImageUser.
+func (p *program) resolveUser(explicit map[string]bool) error {
+	if !explicit["user"] && !explicit["group"] {
+		return nil
+	}
+
+	if !isNumericID(p.group) {
+		gid, err := lookupLocalGroup(p.group)
+		if err != nil {
+			return fmt.Errorf("group %q: %w", p.group, err)
+		}
+
+		if gid != "" {
+			p.group = gid
+		}
+	}
+
+	if !explicit["user"] {
+		return nil
+	}
+
+	u, err := lookupLocalUser(p.user)
+	if err != nil {
+		return fmt.Errorf("user %q: %w", p.user, err)
+	}
+
+	if u != nil {
+		p.user = u.Uid
+
+		if !explicit["group"] {
+			p.group = u.Gid
+		}
+	}
+
+	if p.user == strconv.Itoa(os.Getuid()) {
+		return nil
+	}
+
+	home := ""
+
+	if u != nil {
+		home = u.HomeDir
+	}
+
+	p.userHome = &home
+
+	if ownHome, err := os.UserHomeDir(); err == nil {
+		for path := range homeMounts(ownHome) {
+			// The working directory stays mounted even when it's within the
+			// home directory.
+			if path != p.workdir && p.mounts.origin(path) == mountOriginDefault {
+				p.mounts.remove(path)
+			}
+		}
+	}
+
+	if home == "" {
+		return nil
+	}
+
+	return applyHomeMounts(p.mounts, home)
+}
+
+// colonFileHasName reports whether a passwd or group file contains an entry
+// with the given name.
+func colonFileHasName(content []byte, name string) bool {
+	for line := range strings.Lines(string(content)) {
+		if entry, _, ok := strings.Cut(line, ":"); ok && entry == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkImageUser verifies that user and group names unknown locally are
+// defined within the image.
+func (p *program) checkImageUser(ctx context.Context, backend containerBackend) error {
+	names := map[string]string{}
+
+	if !isNumericID(p.user) {
+		names[passwdPath] = p.user
+	}
+
+	if !isNumericID(p.group) {
+		names[groupPath] = p.group
+	}
+
+	if len(names) == 0 || p.simulated() {
+		return nil
+	}
+
+	var paths []string
+
+	for _, path := range []string{passwdPath, groupPath} {
+		if _, ok := names[path]; ok {
+			paths = append(paths, path)
+		}
+	}
+
+	files, err := backend.readImageFiles(ctx, p.image, paths)
+	if err != nil {
+		return fmt.Errorf("reading image files: %w", err)
+	}
+
+	for _, path := range paths {
+		kind := "user"
+
+		if path == groupPath {
+			kind = "group"
+		}
+
+		if !colonFileHasName(files[path], names[path]) {
+			return fmt.Errorf("%s %q exists neither locally nor in %s of the image", kind, names[path], path)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/hansmi/cocoon/internal/testutil"
+)
+
+func TestProgramResolveUser(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("Current user unknown: %v", err)
+	}
+
+	other, err := user.Lookup("daemon")
+	if err != nil || other.Uid == current.Uid {
+		t.Skipf("No suitable other user: %v", err)
+	}
+
+	ownHome, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uid := strconv.Itoa(os.Getuid())
+	gid := strconv.Itoa(os.Getgid())
+
+	for _, tc := range []struct {
+		name      string
+		user      string
+		group     string
+		explicit  []string
+		wantUser  string
+		wantGroup string
+		wantHome  *string
+		wantMount string
+	}{
+		{
+			name:      "defaults",
+			user:      uid,
+			group:     gid,
+			wantUser:  uid,
+			wantGroup: gid,
+			wantMount: ownHome,
+		},
+		{
+			name:      "local name",
+			user:      current.Username,
+			group:     gid,
+			explicit:  []string{"user"},
+			wantUser:  uid,
+			wantGroup: current.Gid,
+			wantMount: ownHome,
+		},
+		{
+			name:      "other user",
+			user:      other.Username,
+			group:     gid,
+			explicit:  []string{"user"},
+			wantUser:  other.Uid,
+			wantGroup: other.Gid,
+			wantHome:  &other.HomeDir,
+			wantMount: other.HomeDir,
+		},
+		{
+			name:      "other user with group",
+			user:      other.Uid,
+			group:     "1234",
+			explicit:  []string{"user", "group"},
+			wantUser:  other.Uid,
+			wantGroup: "1234",
+			wantHome:  &other.HomeDir,
+			wantMount: other.HomeDir,
+		},
+		{
+			name:      "image user",
+			user:      "cocoon-image-only",
+			group:     "cocoon-image-only",
+			explicit:  []string{"user", "group"},
+			wantUser:  "cocoon-image-only",
+			wantGroup: "cocoon-image-only",
+			wantHome:  new(string),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newProgram()
+			p.user = tc.user
+			p.group = tc.group
+
+			if err := applyHomeMounts(p.mounts, ownHome); err != nil {
+				t.Fatal(err)
+			}
+
+			explicit := map[string]bool{}
+
+			for _, name := range tc.explicit {
+				explicit[name] = true
+			}
+
+			if err := p.resolveUser(explicit); err != nil {
+				t.Fatalf("resolveUser() failed: %v", err)
+			}
+
+			if diff := cmp.Diff(tc.wantUser+":"+tc.wantGroup, p.user+":"+p.group); diff != "" {
+				t.Errorf("User and group diff (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.wantHome, p.userHome); diff != "" {
+				t.Errorf("Home diff (-want +got):\n%s", diff)
+			}
+
+			var homeMounts []string
+
+			for _, path := range []string{ownHome, other.HomeDir} {
+				if p.mounts.has(path) {
+					homeMounts = append(homeMounts, path)
+				}
+			}
+
+			var wantMounts []string
+
+			if tc.wantMount != "" {
+				wantMounts = append(wantMounts, tc.wantMount)
+			}
+
+			if diff := cmp.Diff(wantMounts, homeMounts); diff != "" {
+				t.Errorf("Home mount diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProgramResolveUserHomeMounts(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("Current user unknown: %v", err)
+	}
+
+	other, err := user.Lookup("nobody")
+	if err != nil || other.Uid == current.Uid {
+		t.Skipf("No suitable other user: %v", err)
+	}
+
+	if ok, err := fileExists(other.HomeDir); err != nil || ok {
+		t.Skipf("Home directory of %s exists: %v", other.Username, err)
+	}
+
+	ownHome, err := os.UserHomeDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		workdir string
+		want    []bindMount
+	}{
+		{
+			name:    "outside home",
+			workdir: "/work",
+			want: []bindMount{
+				{src: "/work", dst: "/work", mode: mountReadWrite},
+			},
+		},
+		{
+			name:    "home as workdir",
+			workdir: ownHome,
+			want: []bindMount{
+				{src: ownHome, dst: ownHome, mode: mountReadWrite},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newProgram()
+			p.user = other.Username
+			p.workdir = tc.workdir
+
+			if err := applyHomeMounts(p.mounts, ownHome); err != nil {
+				t.Fatal(err)
+			}
+
+			p.mounts.set(tc.workdir, mountReadWrite, mountOriginDefault)
+
+			if err := p.resolveUser(map[string]bool{"user": true}); err != nil {
+				t.Fatalf("resolveUser() failed: %v", err)
+			}
+
+			if diff := cmp.Diff(tc.want, p.mounts.list(), cmp.AllowUnexported(bindMount{})); diff != "" {
+				t.Errorf("Mount list diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProgramCheckImageUser(t *testing.T) {
+	rootfs := t.TempDir()
+
+	if err := os.Mkdir(filepath.Join(rootfs, "etc"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.MustWriteFile(t, filepath.Join(rootfs, "etc", "passwd"), "root:x:0:0:root:/root:/bin/sh\npostgres:x:70:70::/var/lib/postgresql:/bin/sh\n")
+	testutil.MustWriteFile(t, filepath.Join(rootfs, "etc", "group"), "root:x:0:\npostgres:x:70:\n")
+
+	for _, tc := range []struct {
+		name    string
+		user    string
+		group   string
+		wantErr error
+	}{
+		{name: "numeric", user: "1000", group: "100"},
+		{name: "names", user: "postgres", group: "postgres"},
+		{name: "unknown user", user: "builder", group: "0", wantErr: cmpopts.AnyError},
+		{name: "unknown group", user: "0", group: "builder", wantErr: cmpopts.AnyError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newProgram()
+			p.user = tc.user
+			p.group = tc.group
+
+			err := p.checkImageUser(context.Background(), newBwrapBackend("bwrap", rootfs))
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("checkImageUser() error diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/alecthomas/kingpin/v2"
+)
+
+var errVolumeSpecInvalid = errors.New("invalid volume specification")
+
+// Volume names accepted by Docker.
+var volumeNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+// Mount of a named volume managed by the container runtime.
+type volumeMount struct {
+	name string
+	dst  string
+	mode mountMode
+}
+
+// parseVolumeSpec parses a volume specification in the form
+// "NAME:DST[:ro|rw]". Volumes are writable by default.
+func parseVolumeSpec(value string) (volumeMount, error) {
+	parts := strings.Split(value, ":")
+
+	if len(parts) < 2 || len(parts) > 3 {
+		return volumeMount{}, fmt.Errorf("%w: expected NAME:DST[:ro|rw]: %q", errVolumeSpecInvalid, value)
+	}
+
+	result := volumeMount{
+		name: parts[0],
+		dst:  parts[1],
+		mode: mountReadWrite,
+	}
+
+	if !volumeNamePattern.MatchString(result.name) {
+		return volumeMount{}, fmt.Errorf("%w: name must match %s: %q", errVolumeSpecInvalid, volumeNamePattern, value)
+	}
+
+	if !filepath.IsAbs(result.dst) {
+		return volumeMount{}, fmt.Errorf("%w: destination must be an absolute path: %q", errVolumeSpecInvalid, value)
+	}
+
+	result.dst = filepath.Clean(result.dst)
+
+	if len(parts) > 2 {
+		switch parts[2] {
+		case mountReadOnly.String():
+			result.mode = mountReadOnly
+		case mountReadWrite.String():
+		default:
+			return volumeMount{}, fmt.Errorf("%w: unknown mode %q", errVolumeSpecInvalid, parts[2])
+		}
+	}
+
+	return result, nil
+}
+
+// projectVolumeName returns a volume name unique to a project directory. The
+// directory name is included for readability.
+func projectVolumeName(projectDir, name string) string {
+	sum := sha256.Sum256([]byte(projectDir))
+
+	return fmt.Sprintf("cocoon-%s-%s-%s", sanitizeObjectName(filepath.Base(projectDir)), hex.EncodeToString(sum[:4]), name)
+}
+
+// Set of volumes keyed by their destination within the container.
+type volumeSet struct {
+	entries map[string]volumeMount
+}
+
+var _ fmt.Stringer = (*volumeSet)(nil)
+
+func newVolumeSet() *volumeSet {
+	return &volumeSet{
+		entries: map[string]volumeMount{},
+	}
+}
+
+func (s *volumeSet) String() string {
+	return fmt.Sprint(s.entries)
+}
+
+// set adds a volume. An existing volume at the same destination is replaced.
+func (s *volumeSet) set(v volumeMount) {
+	s.entries[v.dst] = v
+}
+
+func (s *volumeSet) has(dst string) bool {
+	_, ok := s.entries[dst]
+	return ok
+}
+
+// list returns all volumes with parent directories ordered before their
+// children.
+func (s *volumeSet) list() []volumeMount {
+	var result []volumeMount
+
+	for _, dst := range slices.SortedFunc(maps.Keys(s.entries), comparePaths) {
+		result = append(result, s.entries[dst])
+	}
+
+	return result
+}
+
+type volumeSetFlag struct {
+	s *volumeSet
+}
+
+var _ kingpin.Value = (*volumeSetFlag)(nil)
+
+func (f *volumeSetFlag) String() string {
+	return f.s.String()
+}
+
+func (*volumeSetFlag) IsCumulative() bool {
+	return true
+}
+
+func (f *volumeSetFlag) Set(value string) error {
+	v, err := parseVolumeSpec(value)
+	if err != nil {
+		return err
+	}
+
+	f.s.set(v)
+
+	return nil
+}
+
+func volumeSetVar(s kingpin.Settings, target *volumeSet) {
+	s.SetValue(&volumeSetFlag{
+		s: target,
+	})
+}
+
+// volumeMounts returns the configured volumes with their final names.
+func (p *program) volumeMounts() []volumeMount {
+	result := p.volumes.list()
+
+	if p.volumeProjectScope {
+		for idx := range result {
+			result[idx].name = projectVolumeName(p.projectDir, result[idx].name)
+		}
+	}
+
+	return result
+}
+
+// pruneVolumes removes the volumes created for the current project or, if
+// requested, all volumes created by cocoon. Volumes still in use by a
+// container can't be removed and are reported as errors.
+//
+// Volumes without a project-scoped name were created with
+// --no-volume-project-scope and may be shared with other projects. They're
+// labelled with whichever project created them first and are therefore only
+// removed with --all.
+func (p *program) pruneVolumes(ctx context.Context) error {
+	cli, err := p.containerCli()
+	if err != nil {
+		return err
+	}
+
+	filter := projectLabel
+
+	if !p.pruneAllVolumes {
+		filter += "=" + p.projectDir
+	}
+
+	output, err := cli.output(ctx, "volume", "ls", "--quiet", "--filter=label="+filter)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+
+	scopePrefix := projectVolumeName(p.projectDir, "")
+
+	for _, name := range strings.Fields(output) {
+		if !(p.pruneAllVolumes || strings.HasPrefix(name, scopePrefix)) {
+			log.Printf("Warning: not removing volume %s shared between projects, use --all to remove it", name)
+			continue
+		}
+
+		if _, err := cli.output(ctx, "volume", "rm", name); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		fmt.Fprintln(p.stdout, name)
+	}
+
+	return errors.Join(errs...)
+}
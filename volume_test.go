@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alecthomas/kingpin/v2"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/hansmi/cocoon/internal/testutil"
+)
+
+func TestParseVolumeSpec(t *testing.T) {
+	for _, tc := range []struct {
+		value   string
+		want    volumeMount
+		wantErr error
+	}{
+		{
+			value: "gomod:/go/pkg/mod",
+			want:  volumeMount{name: "gomod", dst: "/go/pkg/mod", mode: mountReadWrite},
+		},
+		{
+			value: "npm-cache:/root/.npm/:ro",
+			want:  volumeMount{name: "npm-cache", dst: "/root/.npm", mode: mountReadOnly},
+		},
+		{
+			value: "cargo_1.2:/cargo:rw",
+			want:  volumeMount{name: "cargo_1.2", dst: "/cargo", mode: mountReadWrite},
+		},
+		{value: "name", wantErr: errVolumeSpecInvalid},
+		{value: "x:/data", wantErr: errVolumeSpecInvalid},
+		{value: "/host:/data", wantErr: errVolumeSpecInvalid},
+		{value: "name:relative", wantErr: errVolumeSpecInvalid},
+		{value: "name:/data:xyz", wantErr: errVolumeSpecInvalid},
+		{value: "name:/data:ro:extra", wantErr: errVolumeSpecInvalid},
+	} {
+		t.Run(tc.value, func(t *testing.T) {
+			got, err := parseVolumeSpec(tc.value)
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("parseVolumeSpec() error diff (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.want, got, cmp.AllowUnexported(volumeMount{})); diff != "" {
+				t.Errorf("parseVolumeSpec() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProjectVolumeName(t *testing.T) {
+	first := projectVolumeName("/home/user/src/my project", "cache")
+
+	if !volumeNamePattern.MatchString(first) {
+		t.Errorf("Volume name %q is invalid", first)
+	}
+
+	if !strings.HasPrefix(first, "cocoon-my_project-") || !strings.HasSuffix(first, "-cache") {
+		t.Errorf("Unexpected volume name %q", first)
+	}
+
+	if second := projectVolumeName("/srv/my project", "cache"); first == second {
+		t.Errorf("Volume names for different projects are the same: %q", first)
+	}
+}
+
+func TestProgramVolumeMounts(t *testing.T) {
+	p := newProgram()
+	p.projectDir = "/src/project"
+
+	app := kingpin.New(t.Name(), "")
+
+	volumeSetVar(app.Flag("volume", ""), p.volumes)
+
+	if _, err := app.Parse([]string{
+		"--volume=first:/data",
+		"--volume=gomod:/go/pkg/mod",
+		"--volume=second:/data",
+	}); err != nil {
+		t.Errorf("Parsing flags failed: %v", err)
+	}
+
+	if diff := cmp.Diff([]volumeMount{
+		{name: "second", dst: "/data", mode: mountReadWrite},
+		{name: "gomod", dst: "/go/pkg/mod", mode: mountReadWrite},
+	}, p.volumeMounts(), cmp.AllowUnexported(volumeMount{})); diff != "" {
+		t.Errorf("volumeMounts() diff (-want +got):\n%s", diff)
+	}
+
+	p.volumeProjectScope = true
+
+	if diff := cmp.Diff([]volumeMount{
+		{name: projectVolumeName(p.projectDir, "second"), dst: "/data", mode: mountReadWrite},
+		{name: projectVolumeName(p.projectDir, "gomod"), dst: "/go/pkg/mod", mode: mountReadWrite},
+	}, p.volumeMounts(), cmp.AllowUnexported(volumeMount{})); diff != "" {
+		t.Errorf("volumeMounts() diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestPruneVolumes(t *testing.T) {
+	tmpdir := t.TempDir()
+	logFile := filepath.Join(tmpdir, "log")
+
+	const projectDir = "/src/project"
+
+	vol1 := projectVolumeName(projectDir, "vol1")
+	vol2 := projectVolumeName(projectDir, "vol2")
+
+	program := testutil.MustWriteExecutable(t, filepath.Join(tmpdir, "docker"), `#!/bin/sh
+echo "$*" >> '`+logFile+`'
+case "$1 $2" in
+"volume ls") printf '`+vol1+`\n`+vol2+`\nshared\n' ;;
+"volume rm") [ "$3" != '`+vol2+`' ] ;;
+esac
+`)
+
+	for _, tc := range []struct {
+		name       string
+		all        bool
+		wantOutput string
+		wantLog    []string
+	}{
+		{
+			name:       "project",
+			wantOutput: vol1 + "\n",
+			wantLog: []string{
+				"volume ls --quiet --filter=label=" + projectLabel + "=" + projectDir,
+				"volume rm " + vol1,
+				"volume rm " + vol2,
+			},
+		},
+		{
+			name:       "all",
+			all:        true,
+			wantOutput: vol1 + "\nshared\n",
+			wantLog: []string{
+				"volume ls --quiet --filter=label=" + projectLabel,
+				"volume rm " + vol1,
+				"volume rm " + vol2,
+				"volume rm shared",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := os.RemoveAll(logFile); err != nil {
+				t.Fatal(err)
+			}
+
+			var stdout strings.Builder
+
+			p := newProgram()
+			p.stdout = &stdout
+			p.containerEngine = string(engineDocker)
+			p.dockerCliProgram = program
+			p.projectDir = projectDir
+			p.pruneAllVolumes = tc.all
+
+			if err := p.pruneVolumes(context.Background()); err == nil {
+				t.Errorf("pruneVolumes() succeeded despite failing removal")
+			}
+
+			if diff := cmp.Diff(tc.wantOutput, stdout.String()); diff != "" {
+				t.Errorf("Output diff (-want +got):\n%s", diff)
+			}
+
+			log, err := os.ReadFile(logFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(strings.Join(append(tc.wantLog, ""), "\n"), string(log)); diff != "" {
+				t.Errorf("Invocation diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	waylandDisplayEnv     = "WAYLAND_DISPLAY"
+	defaultWaylandDisplay = "wayland-0"
+)
+
+// setupWayland mounts the socket of the local Wayland compositor into the
+// container's runtime directory.
+func (p *program) setupWayland(r *runtime, mounts *mountSet, env envMap) error {
+	display := os.Getenv(waylandDisplayEnv)
+	if display == "" {
+		display = defaultWaylandDisplay
+	}
+
+	socket := display
+
+	if !filepath.IsAbs(socket) {
+		socket = filepath.Join(hostRuntimeDir(), socket)
+	}
+
+	if ok, err := fileExists(socket); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("compositor socket not found at %s", socket)
+	}
+
+	name := filepath.Base(socket)
+
+	if _, err := bindRuntimeSocket(r, mounts, env, mountOriginWayland, socket, name); err != nil {
+		return err
+	}
+
+	env[waylandDisplayEnv] = &name
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hansmi/cocoon/internal/testutil"
+)
+
+func TestProgramSetupWayland(t *testing.T) {
+	runtimeDir := t.TempDir()
+	otherDir := t.TempDir()
+
+	testutil.MustWriteFile(t, filepath.Join(runtimeDir, "wayland-1"), "")
+	testutil.MustWriteFile(t, filepath.Join(otherDir, "compositor"), "")
+
+	t.Setenv(xdgRuntimeDirEnv, runtimeDir)
+
+	for _, tc := range []struct {
+		name    string
+		display string
+		socket  string
+		wantErr string
+	}{
+		{name: "default", wantErr: filepath.Join(runtimeDir, "wayland-0")},
+		{name: "relative", display: "wayland-1", socket: filepath.Join(runtimeDir, "wayland-1")},
+		{name: "absolute", display: filepath.Join(otherDir, "compositor"), socket: filepath.Join(otherDir, "compositor")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv(waylandDisplayEnv, tc.display)
+
+			p := newProgram()
+
+			r := &runtime{}
+			t.Cleanup(func() { os.RemoveAll(r.baseDir) })
+
+			mounts := newMountSet()
+			env := envMap{}
+
+			err := p.setupWayland(r, mounts, env)
+
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("setupWayland() error %v doesn't mention %q", err, tc.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("setupWayland() failed: %v", err)
+			}
+
+			dir := env[xdgRuntimeDirEnv]
+			if dir == nil {
+				t.Fatalf("%s not set", xdgRuntimeDirEnv)
+			}
+
+			name := filepath.Base(tc.socket)
+
+			if got := env[waylandDisplayEnv]; got == nil || *got != name {
+				t.Errorf("%s is %v, want %q", waylandDisplayEnv, got, name)
+			}
+
+			if diff := cmp.Diff([]bindMount{
+				{src: *dir, dst: *dir, mode: mountReadWrite},
+				{src: tc.socket, dst: filepath.Join(*dir, name), mode: mountReadOnly},
+			}, mounts.list(), cmp.AllowUnexported(bindMount{})); diff != "" {
+				t.Errorf("Mount list diff (-want +got):\n%s", diff)
+			}
+
+			if ok, err := fileExists(filepath.Join(*dir, name)); err != nil || !ok {
+				t.Errorf("Mount point missing: %v", err)
+			}
+		})
+	}
+}
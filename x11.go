@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	displayEnv      = "DISPLAY"
+	xauthorityEnv   = "XAUTHORITY"
+	x11SocketDir    = "/tmp/.X11-unix"
+	x11SocketPrefix = "X"
+)
+
+// Address families used in Xauthority files.
+const (
+	xauthFamilyLocal uint16 = 256
+	xauthFamilyWild  uint16 = 65535
+)
+
+var errX11DisplayInvalid = errors.New("invalid X11 display")
+
+type x11Display struct {
+	// Host name, empty for local displays.
+	host string
+
+	number string
+}
+
+// parseX11Display parses a display name in the form "[HOST]:NUMBER[.SCREEN]".
+func parseX11Display(value string) (x11Display, error) {
+	idx := strings.LastIndex(value, ":")
+	if idx < 0 {
+		return x11Display{}, fmt.Errorf("%w: %q", errX11DisplayInvalid, value)
+	}
+
+	result := x11Display{
+		host: value[:idx],
+	}
+
+	result.number, _, _ = strings.Cut(value[idx+1:], ".")
+
+	if _, err := strconv.ParseUint(result.number, 10, 16); err != nil {
+		return x11Display{}, fmt.Errorf("%w: %q: display number: %v", errX11DisplayInvalid, value, err)
+	}
+
+	if result.host == "unix" {
+		result.host = ""
+	}
+
+	return result, nil
+}
+
+// socketPath returns the location of the display's Unix socket within the
+// given directory. Remote displays have no socket.
+func (d x11Display) socketPath(dir string) string {
+	if d.host != "" {
+		return ""
+	}
+
+	return filepath.Join(dir, x11SocketPrefix+d.number)
+}
+
+type xauthEntry struct {
+	family  uint16
+	address string
+	number  string
+	name    string
+	data    []byte
+}
+
+func readXauthField(r io.Reader) ([]byte, error) {
+	var length uint16
+
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, length)
+
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// readXauthority parses the entries of an Xauthority file.
+func readXauthority(r io.Reader) ([]xauthEntry, error) {
+	br := bufio.NewReader(r)
+
+	var result []xauthEntry
+
+	for {
+		var entry xauthEntry
+
+		if err := binary.Read(br, binary.BigEndian, &entry.family); err != nil {
+			if errors.Is(err, io.EOF) {
+				return result, nil
+			}
+
+			return nil, err
+		}
+
+		var fields [4][]byte
+
+		for idx := range fields {
+			buf, err := readXauthField(br)
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					err = io.ErrUnexpectedEOF
+				}
+
+				return nil, fmt.Errorf("Xauthority entry %d: %w", len(result), err)
+			}
+
+			fields[idx] = buf
+		}
+
+		entry.address = string(fields[0])
+		entry.number = string(fields[1])
+		entry.name = string(fields[2])
+		entry.data = fields[3]
+
+		result = append(result, entry)
+	}
+}
+
+// writeXauthority writes entries in the Xauthority file format.
+func writeXauthority(w io.Writer, entries []xauthEntry) error {
+	bw := bufio.NewWriter(w)
+
+	for _, entry := range entries {
+		if err := binary.Write(bw, binary.BigEndian, entry.family); err != nil {
+			return err
+		}
+
+		for _, field := range [][]byte{
+			[]byte(entry.address),
+			[]byte(entry.number),
+			[]byte(entry.name),
+			entry.data,
+		} {
+			if len(field) > 0xffff {
+				return errors.New("Xauthority field too long")
+			}
+
+			if err := binary.Write(bw, binary.BigEndian, uint16(len(field))); err != nil {
+				return err
+			}
+
+			if _, err := bw.Write(field); err != nil {
+				return err
+			}
+		}
+	}
+
+	return bw.Flush()
+}
+
+// findXauthEntry returns the first entry applicable to a display. Entries
+// for local connections must name the local host.
+func findXauthEntry(entries []xauthEntry, display x11Display, hostname string) (xauthEntry, bool) {
+	for _, entry := range entries {
+		if entry.number != display.number {
+			continue
+		}
+
+		switch entry.family {
+		case xauthFamilyWild:
+		case xauthFamilyLocal:
+			if entry.address != hostname {
+				continue
+			}
+		default:
+			if display.host == "" {
+				continue
+			}
+		}
+
+		return entry, true
+	}
+
+	return xauthEntry{}, false
+}
+
+// writeContainerXauthority creates an Xauthority file in the runtime's base
+// directory containing only the cookie for the display. The entry applies to
+// any host name as the container's host name may differ.
+func writeContainerXauthority(r *runtime, path string, display x11Display) (_ string, err error) {
+	fh, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	defer fh.Close()
+
+	entries, err := readXauthority(fh)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+
+	entry, ok := findXauthEntry(entries, display, hostname)
+	if !ok {
+		return "", nil
+	}
+
+	entry.family = xauthFamilyWild
+	entry.address = ""
+
+	out, err := r.createFile("Xauthority")
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		err = errors.Join(err, out.Close())
+	}()
+
+	if err := writeXauthority(out, []xauthEntry{entry}); err != nil {
+		return "", fmt.Errorf("writing %s: %w", out.Name(), err)
+	}
+
+	return out.Name(), nil
+}
+
+// setupX11 makes the display named by the DISPLAY environment variable
+// available within the container. The display socket is mounted individually
+// to place it on top of a temporary filesystem at /tmp.
+func (p *program) setupX11(r *runtime, mounts *mountSet, env envMap) error {
+	value := os.Getenv(displayEnv)
+	if value == "" {
+		return fmt.Errorf("environment variable %q is unset or empty", displayEnv)
+	}
+
+	display, err := parseX11Display(value)
+	if err != nil {
+		return err
+	}
+
+	if socket := display.socketPath(p.x11SocketDir); socket != "" {
+		if ok, err := fileExists(socket); err != nil {
+			return err
+		} else if !ok {
+			return fmt.Errorf("socket for display %q not found at %s", value, socket)
+		}
+
+		mounts.bind(socket, display.socketPath(x11SocketDir), mountReadOnly, mountOriginX11)
+	}
+
+	xauthority := os.Getenv(xauthorityEnv)
+
+	if xauthority == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("getting home dir: %w", err)
+		}
+
+		xauthority = filepath.Join(home, ".Xauthority")
+	}
+
+	containerXauthority, err := writeContainerXauthority(r, xauthority, display)
+	if err != nil {
+		return err
+	}
+
+	env[displayEnv] = &value
+
+	if containerXauthority != "" {
+		mounts.set(containerXauthority, mountReadOnly, mountOriginX11)
+		env[xauthorityEnv] = &containerXauthority
+	}
+
+	return nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestParseX11Display(t *testing.T) {
+	for _, tc := range []struct {
+		value   string
+		want    x11Display
+		wantErr error
+	}{
+		{value: ":0", want: x11Display{number: "0"}},
+		{value: ":1.0", want: x11Display{number: "1"}},
+		{value: "unix:2", want: x11Display{number: "2"}},
+		{value: "localhost:10.0", want: x11Display{host: "localhost", number: "10"}},
+		{value: "[::1]:3", want: x11Display{host: "[::1]", number: "3"}},
+		{value: "", wantErr: errX11DisplayInvalid},
+		{value: "host", wantErr: errX11DisplayInvalid},
+		{value: ":x", wantErr: errX11DisplayInvalid},
+		{value: ":/0", wantErr: errX11DisplayInvalid},
+	} {
+		t.Run(tc.value, func(t *testing.T) {
+			got, err := parseX11Display(tc.value)
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("parseX11Display() error diff (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.want, got, cmp.AllowUnexported(x11Display{})); diff != "" {
+				t.Errorf("parseX11Display() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestXauthority(t *testing.T) {
+	entries := []xauthEntry{
+		{family: xauthFamilyLocal, address: "other", number: "0", name: "MIT-MAGIC-COOKIE-1", data: []byte{1}},
+		{family: xauthFamilyLocal, address: "host", number: "0", name: "MIT-MAGIC-COOKIE-1", data: []byte{2}},
+		{family: xauthFamilyWild, number: "1", name: "MIT-MAGIC-COOKIE-1", data: []byte{3}},
+		{family: 0, address: "\x7f\x00\x00\x01", number: "10", name: "MIT-MAGIC-COOKIE-1", data: []byte{4}},
+	}
+
+	var buf bytes.Buffer
+
+	if err := writeXauthority(&buf, entries); err != nil {
+		t.Fatalf("writeXauthority() failed: %v", err)
+	}
+
+	got, err := readXauthority(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Errorf("readXauthority() failed: %v", err)
+	}
+
+	opts := []cmp.Option{cmp.AllowUnexported(xauthEntry{}), cmpopts.EquateEmpty()}
+
+	if diff := cmp.Diff(entries, got, opts...); diff != "" {
+		t.Errorf("readXauthority() diff (-want +got):\n%s", diff)
+	}
+
+	if _, err := readXauthority(bytes.NewReader(buf.Bytes()[:buf.Len()-1])); err == nil {
+		t.Errorf("readXauthority() succeeded with truncated data")
+	}
+
+	for _, tc := range []struct {
+		display string
+		want    *xauthEntry
+	}{
+		{display: ":0", want: &entries[1]},
+		{display: ":1", want: &entries[2]},
+		{display: ":2"},
+		{display: ":10"},
+		{display: "localhost:10", want: &entries[3]},
+	} {
+		t.Run(tc.display, func(t *testing.T) {
+			display, err := parseX11Display(tc.display)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, ok := findXauthEntry(entries, display, "host")
+
+			if tc.want == nil {
+				if ok {
+					t.Errorf("findXauthEntry() returned %v", got)
+				}
+			} else if diff := cmp.Diff(*tc.want, got, opts...); diff != "" {
+				t.Errorf("findXauthEntry() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProgramSetupX11(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpdir := t.TempDir()
+	socketDir := filepath.Join(tmpdir, "X11-unix")
+	xauthority := filepath.Join(tmpdir, "Xauthority")
+
+	if err := os.Mkdir(socketDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(socketDir, "X3"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	if err := writeXauthority(&buf, []xauthEntry{
+		{family: xauthFamilyLocal, address: hostname, number: "2", name: "MIT-MAGIC-COOKIE-1", data: []byte("other")},
+		{family: xauthFamilyLocal, address: hostname, number: "3", name: "MIT-MAGIC-COOKIE-1", data: []byte("cookie")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(xauthority, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(xauthorityEnv, xauthority)
+
+	newProgramWithRuntime := func(t *testing.T) (*program, *runtime) {
+		p := newProgram()
+		p.x11SocketDir = socketDir
+
+		r := &runtime{}
+		t.Cleanup(func() { os.RemoveAll(r.baseDir) })
+
+		return p, r
+	}
+
+	t.Run("local", func(t *testing.T) {
+		t.Setenv(displayEnv, ":3.0")
+
+		p, r := newProgramWithRuntime(t)
+		mounts := newMountSet()
+		env := envMap{}
+
+		if err := p.setupX11(r, mounts, env); err != nil {
+			t.Fatalf("setupX11() failed: %v", err)
+		}
+
+		if got := env[displayEnv]; got == nil || *got != ":3.0" {
+			t.Errorf("%s is %v", displayEnv, got)
+		}
+
+		containerXauthority := env[xauthorityEnv]
+		if containerXauthority == nil {
+			t.Fatalf("%s not set", xauthorityEnv)
+		}
+
+		if diff := cmp.Diff([]bindMount{
+			{src: *containerXauthority, dst: *containerXauthority, mode: mountReadOnly},
+			{src: filepath.Join(socketDir, "X3"), dst: "/tmp/.X11-unix/X3", mode: mountReadOnly},
+		}, mounts.list(), cmp.AllowUnexported(bindMount{}), cmpopts.SortSlices(func(a, b bindMount) bool {
+			return a.dst < b.dst
+		})); diff != "" {
+			t.Errorf("Mount list diff (-want +got):\n%s", diff)
+		}
+
+		content, err := os.ReadFile(*containerXauthority)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		entries, err := readXauthority(bytes.NewReader(content))
+		if err != nil {
+			t.Errorf("readXauthority() failed: %v", err)
+		}
+
+		if diff := cmp.Diff([]xauthEntry{
+			{family: xauthFamilyWild, number: "3", name: "MIT-MAGIC-COOKIE-1", data: []byte("cookie")},
+		}, entries, cmp.AllowUnexported(xauthEntry{}), cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("Xauthority diff (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("remote without cookie", func(t *testing.T) {
+		t.Setenv(displayEnv, "localhost:10")
+
+		p, r := newProgramWithRuntime(t)
+		mounts := newMountSet()
+		env := envMap{}
+
+		if err := p.setupX11(r, mounts, env); err != nil {
+			t.Fatalf("setupX11() failed: %v", err)
+		}
+
+		if got := mounts.list(); len(got) != 0 {
+			t.Errorf("Unexpected mounts: %v", got)
+		}
+
+		if _, ok := env[xauthorityEnv]; ok {
+			t.Errorf("%s set without cookie", xauthorityEnv)
+		}
+	})
+
+	for _, display := range []string{"", ":4", "invalid"} {
+		t.Run("error "+display, func(t *testing.T) {
+			t.Setenv(displayEnv, display)
+
+			p, r := newProgramWithRuntime(t)
+
+			if err := p.setupX11(r, newMountSet(), envMap{}); err == nil {
+				t.Errorf("setupX11() succeeded")
+			}
+		})
+	}
+}
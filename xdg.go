@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const xdgRuntimeDirEnv = "XDG_RUNTIME_DIR"
+
+// hostRuntimeDir returns the local user's runtime directory. The location
+// used by systemd-logind is assumed when the environment doesn't name one.
+func hostRuntimeDir() string {
+	if dir := os.Getenv(xdgRuntimeDirEnv); dir != "" {
+		return dir
+	}
+
+	return fmt.Sprintf("/run/user/%d", os.Getuid())
+}
+
+// containerRuntimeDir returns the runtime directory for the container. The
+// directory is created in the runtime's base directory on first use and only
+// contains the sockets forwarded explicitly.
+func containerRuntimeDir(r *runtime, mounts *mountSet, env envMap, origin mountOrigin) (string, error) {
+	if dir := env[xdgRuntimeDirEnv]; dir != nil {
+		return *dir, nil
+	}
+
+	dir, err := r.createDir("xdg-runtime")
+	if err != nil {
+		return "", err
+	}
+
+	mounts.set(dir, mountReadWrite, origin)
+	env[xdgRuntimeDirEnv] = &dir
+
+	return dir, nil
+}
+
+// bindRuntimeSocket mounts a local socket at a path relative to the
+// container's runtime directory. The mount point is created beforehand to
+// keep it owned by the user.
+func bindRuntimeSocket(r *runtime, mounts *mountSet, env envMap, origin mountOrigin, socket, name string) (string, error) {
+	dir, err := containerRuntimeDir(r, mounts, env, origin)
+	if err != nil {
+		return "", err
+	}
+
+	dst := filepath.Join(dir, name)
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+		return "", err
+	}
+
+	if err := createMountPoint(dst); err != nil {
+		return "", err
+	}
+
+	mounts.bind(socket, dst, mountReadOnly, origin)
+
+	return dst, nil
+}